@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AccessList lists workspaces, projects and repositories by UUID or by name, with globs. Workspaces are given by slug,
+// projects by key and repositories by full name (workspace/slug).
+type AccessList struct {
+	Workspaces   []string `json:"workspaces,omitempty"`
+	Projects     []string `json:"projects,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+}
+
+func (l *AccessList) Validate() error {
+	for _, patterns := range [][]string{l.Workspaces, l.Projects, l.Repositories} {
+		for _, pattern := range patterns {
+			if !validPath(pattern) {
+				return fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *AccessList) Empty() bool {
+	return len(l.Workspaces) == 0 && len(l.Projects) == 0 && len(l.Repositories) == 0
+}
+
+// Match returns the first pattern matching the repository, its workspace or its project, or an empty string.
+func (l *AccessList) Match(r *Repository) string {
+	var workspaces, projects []string
+	if i := strings.Index(r.FullName, "/"); i > 0 {
+		workspaces = append(workspaces, r.FullName[:i])
+	}
+	if r.Owner != nil {
+		workspaces = append(workspaces, r.Owner.UUID)
+	}
+	if r.Project != nil {
+		projects = append(projects, r.Project.Key, r.Project.UUID)
+	}
+	repositories := []string{r.FullName, r.Uuid}
+
+	for _, list := range []struct {
+		patterns []string
+		values   []string
+	}{
+		{l.Workspaces, workspaces},
+		{l.Projects, projects},
+		{l.Repositories, repositories},
+	} {
+		for _, pattern := range list.patterns {
+			for _, value := range list.values {
+				if len(value) > 0 && matchAccess(pattern, value) {
+					return pattern
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Names and UUIDs are compared ignoring case and the braces around UUIDs.
+func matchAccess(pattern string, value string) bool {
+	matched, _ := path.Match(normalizeRepositoryKey(pattern), normalizeRepositoryKey(value))
+	return matched
+}
+
+// AccessConfig restricts the repositories the service clones and pushes to. A repository matching the deny list is
+// refused, otherwise it must match the allow list unless the allow list is empty.
+type AccessConfig struct {
+	Allow AccessList `json:"allow"`
+	Deny  AccessList `json:"deny"`
+}
+
+func (c *AccessConfig) Validate() error {
+	err := c.Allow.Validate()
+	if err != nil {
+		return fmt.Errorf("allow: %s", err)
+	}
+	err = c.Deny.Validate()
+	if err != nil {
+		return fmt.Errorf("deny: %s", err)
+	}
+	return nil
+}
+
+// Allowed tells whether the service may work on the repository, and why not. A nil configuration allows everything.
+func (c *AccessConfig) Allowed(r *Repository) (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+	if r == nil {
+		return false, "unknown repository"
+	}
+	if pattern := c.Deny.Match(r); len(pattern) > 0 {
+		return false, fmt.Sprintf("denied by %s", pattern)
+	}
+	if !c.Allow.Empty() && len(c.Allow.Match(r)) == 0 {
+		return false, "not allowed"
+	}
+	return true, ""
+}
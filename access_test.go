@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestAccessConfig_Allowed(t *testing.T) {
+	winterfell := &Repository{
+		Uuid:     "{787fe82b-970a-4349-bae9-8d07306b18cc}",
+		FullName: "morphean-sa/winterfell",
+		Owner:    &Owner{UUID: "{e353c7b3-3723-43f8-b5da-dbddc0d9f8cb}"},
+		Project:  &Project{Key: "WF", UUID: "{6754a17e-22f7-4c14-a877-18cde4abb753}"},
+	}
+
+	tests := []struct {
+		name   string
+		access *AccessConfig
+		want   bool
+	}{
+		{name: "NoConfig", access: nil, want: true},
+		{name: "EmptyLists", access: &AccessConfig{}, want: true},
+		{name: "Workspace", access: &AccessConfig{Allow: AccessList{Workspaces: []string{"Morphean-SA"}}}, want: true},
+		{name: "WorkspaceUUID", access: &AccessConfig{Allow: AccessList{Workspaces: []string{"e353c7b3-3723-43f8-b5da-dbddc0d9f8cb"}}}, want: true},
+		{name: "Project", access: &AccessConfig{Allow: AccessList{Projects: []string{"WF"}}}, want: true},
+		{name: "RepositoryGlob", access: &AccessConfig{Allow: AccessList{Repositories: []string{"morphean-sa/winter*"}}}, want: true},
+		{name: "RepositoryUUID", access: &AccessConfig{Allow: AccessList{Repositories: []string{"{787FE82B-970A-4349-BAE9-8D07306B18CC}"}}}, want: true},
+		{name: "NotAllowed", access: &AccessConfig{Allow: AccessList{Repositories: []string{"morphean-sa/dragonstone"}, Projects: []string{"DS"}}}, want: false},
+		{name: "GlobStopsAtSlash", access: &AccessConfig{Allow: AccessList{Repositories: []string{"*"}}}, want: false},
+		{name: "Denied", access: &AccessConfig{Allow: AccessList{Workspaces: []string{"morphean-sa"}}, Deny: AccessList{Repositories: []string{"*/winterfell"}}}, want: false},
+		{name: "DeniedProject", access: &AccessConfig{Deny: AccessList{Projects: []string{"W?"}}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := tt.access.Allowed(winterfell); got != tt.want {
+				t.Errorf("Allowed() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessConfig_Validate(t *testing.T) {
+	if err := (&AccessConfig{Allow: AccessList{Repositories: []string{"morphean-sa/*"}}}).Validate(); err != nil {
+		t.Errorf("Validate() = %s", err)
+	}
+	if err := (&AccessConfig{Deny: AccessList{Workspaces: []string{"morphean-[sa"}}}).Validate(); err == nil {
+		t.Error("invalid glob must be rejected")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/ktrysmt/go-bitbucket"
+	"strconv"
 )
 
 type Bitbucket struct {
@@ -55,6 +56,34 @@ func (c *Bitbucket) GetCloneURL(protocols ...string) (string, error) {
 	return "", fmt.Errorf("cannot determine clone url of %s", r.Full_name)
 }
 
+// GetRepository returns the repository as known by Bitbucket, with its owner and project. Unlike an event payload it
+// can be trusted to check the access lists.
+func (c *Bitbucket) GetRepository() (*Repository, error) {
+	opt := &bitbucket.RepositoryOptions{
+		Owner:    c.Owner,
+		RepoSlug: c.RepoSlug,
+	}
+
+	r, err := c.Client.Repositories.Repository.Get(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	repository := &Repository{
+		Uuid:     r.Uuid,
+		Name:     r.Slug,
+		FullName: r.Full_name,
+		Owner:    &Owner{},
+	}
+	if uuid, ok := r.Owner["uuid"].(string); ok {
+		repository.Owner.UUID = uuid
+	}
+	if len(r.Project.Key) > 0 || len(r.Project.Uuid) > 0 {
+		repository.Project = &Project{Key: r.Project.Key, UUID: r.Project.Uuid, Name: r.Project.Name}
+	}
+	return repository, nil
+}
+
 func (c *Bitbucket) GetCascadeOptions(owner, repo string) (*CascadeOptions, error) {
 	opt := &bitbucket.RepositoryBranchingModelOptions{
 		Owner:    c.Owner,
@@ -66,11 +95,18 @@ func (c *Bitbucket) GetCascadeOptions(owner, repo string) (*CascadeOptions, erro
 		return nil, err
 	}
 
+	// production branch is optional, it may follow the main branch
+	production := model.Production.Branch.Name
+	if len(production) == 0 {
+		production = model.Production.Name
+	}
+
 	for _, bt := range model.Branch_Types {
 		if bt.Kind == "release" {
 			return &CascadeOptions{
 				DevelopmentName: model.Development.Name,
 				ReleasePrefix:   bt.Prefix,
+				ProductionName:  production,
 			}, nil
 		}
 	}
@@ -78,7 +114,9 @@ func (c *Bitbucket) GetCascadeOptions(owner, repo string) (*CascadeOptions, erro
 	return nil, fmt.Errorf("cannot inspect branching model on %s", repo)
 }
 
-func (c *Bitbucket) CreatePullRequest(title, description, sourceBranch, destinationBranch string) error {
+// CreatePullRequest opens a pull request and returns its web link, which is empty when the response has none. The
+// reviewers are given by UUID.
+func (c *Bitbucket) CreatePullRequest(title, description, sourceBranch, destinationBranch string, reviewers []string, closeSourceBranch bool) (string, error) {
 	opt := &bitbucket.PullRequestsOptions{
 		Owner:             c.Owner,
 		RepoSlug:          c.RepoSlug,
@@ -86,11 +124,95 @@ func (c *Bitbucket) CreatePullRequest(title, description, sourceBranch, destinat
 		Description:       description,
 		SourceBranch:      sourceBranch,
 		DestinationBranch: destinationBranch,
+		Reviewers:         reviewers,
+		CloseSourceBranch: closeSourceBranch,
+	}
+
+	response, err := c.Client.Repositories.PullRequests.Create(opt)
+	if err != nil {
+		return "", err
+	}
+	return htmlLink(response), nil
+}
+
+// Extract links.html.href of an API response.
+func htmlLink(response interface{}) string {
+	m, ok := response.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	links, ok := m["links"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	html, ok := links["html"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	href, _ := html["href"].(string)
+	return href
+}
+
+// AddPullRequestComment posts a markdown comment on a pull request.
+func (c *Bitbucket) AddPullRequestComment(id int, content string) error {
+	opt := &bitbucket.PullRequestCommentOptions{
+		Owner:         c.Owner,
+		RepoSlug:      c.RepoSlug,
+		PullRequestID: strconv.Itoa(id),
+		Content:       content,
+	}
+
+	_, err := c.Client.Repositories.PullRequests.AddComment(opt)
+	return err
+}
+
+// CreateCommitStatus publishes a build status on a commit, a status with the same key is replaced.
+func (c *Bitbucket) CreateCommitStatus(revision string, status *bitbucket.CommitStatusOptions) error {
+	opt := &bitbucket.CommitsOptions{
+		Owner:    c.Owner,
+		RepoSlug: c.RepoSlug,
+		Revision: revision,
 	}
 
-	_, err := c.Client.Repositories.PullRequests.Create(opt)
+	_, err := c.Client.Repositories.Commits.CreateCommitStatus(opt, status)
+	return err
+}
+
+// GetDefaultReviewers returns the UUIDs of the default reviewers of the repository.
+func (c *Bitbucket) GetDefaultReviewers() ([]string, error) {
+	opt := &bitbucket.RepositoryOptions{
+		Owner:    c.Owner,
+		RepoSlug: c.RepoSlug,
+	}
+
+	reviewers, err := c.Client.Repositories.Repository.ListDefaultReviewers(opt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	uuids := make([]string, 0, len(reviewers.DefaultReviewers))
+	for _, r := range reviewers.DefaultReviewers {
+		uuids = append(uuids, r.Uuid)
 	}
-	return nil
+	return uuids, nil
+}
+
+// GetUser reads the public profile of a user given by UUID or account id.
+func (c *Bitbucket) GetUser(id string) (*bitbucket.User, error) {
+	return c.Client.Users.Get(id)
+}
+
+// CurrentUserUUID returns the UUID of the user the service is authenticated as.
+func (c *Bitbucket) CurrentUserUUID() (string, error) {
+	user, err := c.Client.User.Profile()
+	if err != nil {
+		return "", err
+	}
+	return user.Uuid, nil
+}
+
+// Ping checks the API is reachable and the credentials are accepted.
+func (c *Bitbucket) Ping() error {
+	_, err := c.Client.User.Profile()
+	return err
 }
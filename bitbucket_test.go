@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ktrysmt/go-bitbucket"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// A stub of the Bitbucket API, the handler answers every request.
+func stubBitbucket(handler http.HandlerFunc) (*Bitbucket, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	api := NewBitbucket("jon.snow", "ghost", "morphean-sa", "winterfell")
+
+	u, _ := url.Parse(server.URL)
+	u.Path = "/2.0"
+	api.Client.SetApiBaseURL(*u)
+
+	return api, server
+}
+
+func TestBitbucket_CreateCommitStatus(t *testing.T) {
+	var path string
+	var status bitbucket.CommitStatusOptions
+	api, server := stubBitbucket(func(writer http.ResponseWriter, request *http.Request) {
+		path = request.URL.Path
+		body, _ := ioutil.ReadAll(request.Body)
+		json.Unmarshal(body, &status)
+		writer.Write([]byte("{}"))
+	})
+	defer server.Close()
+
+	err := api.CreateCommitStatus("420b26f539230d0dcd2f5b95bc17d4c1db3ab70b", &bitbucket.CommitStatusOptions{
+		Key:   "cascade-merge",
+		State: CommitStatusSuccessful,
+		Url:   "https://bitbucket.org/morphean-sa/winterfell",
+	})
+	CheckFatal(err, t)
+
+	if want := "/2.0/repositories/morphean-sa/winterfell/commit/420b26f539230d0dcd2f5b95bc17d4c1db3ab70b/statuses/build"; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if status.Key != "cascade-merge" || status.State != CommitStatusSuccessful {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+// The conflict pull request is opened with reviewers. We expect them in the request body and the web link returned.
+func TestBitbucket_CreatePullRequest(t *testing.T) {
+	var body map[string]interface{}
+	api, server := stubBitbucket(func(writer http.ResponseWriter, request *http.Request) {
+		json.NewDecoder(request.Body).Decode(&body)
+		writer.Write([]byte(`{"id": 8, "links": {"html": {"href": "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8"}}}`))
+	})
+	defer server.Close()
+
+	link, err := api.CreatePullRequest("Automatic merge failure", "conflict", "cascade/release-2-into-release-3", "release/3", []string{"{arya}", "{sansa}"}, true)
+	CheckFatal(err, t)
+
+	if link != "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8" {
+		t.Errorf("link = %s", link)
+	}
+	if reviewers := body["reviewers"].([]interface{}); len(reviewers) != 2 || reviewers[1].(map[string]interface{})["uuid"] != "{sansa}" {
+		t.Errorf("reviewers = %v", body["reviewers"])
+	}
+	if body["close_source_branch"] != true {
+		t.Errorf("close_source_branch = %v, want true", body["close_source_branch"])
+	}
+}
+
+func TestBitbucket_GetDefaultReviewers(t *testing.T) {
+	api, server := stubBitbucket(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(`{"values": [{"uuid": "{bran}", "display_name": "Bran Stark"}, {"uuid": "{rickon}", "display_name": "Rickon Stark"}]}`))
+	})
+	defer server.Close()
+
+	reviewers, err := api.GetDefaultReviewers()
+	CheckFatal(err, t)
+
+	if len(reviewers) != 2 || reviewers[0] != "{bran}" || reviewers[1] != "{rickon}" {
+		t.Errorf("reviewers = %v", reviewers)
+	}
+}
+
+// The repository is read to check the access lists. We expect its full name, owner and project.
+func TestBitbucket_GetRepository(t *testing.T) {
+	var path string
+	api, server := stubBitbucket(func(writer http.ResponseWriter, request *http.Request) {
+		path = request.URL.Path
+		writer.Write([]byte(`{"type": "repository", "uuid": "{787fe82b-970a-4349-bae9-8d07306b18cc}", "slug": "winterfell", "full_name": "morphean-sa/winterfell", "owner": {"uuid": "{e353c7b3-3723-43f8-b5da-dbddc0d9f8cb}"}, "project": {"key": "WF", "uuid": "{6754a17e-22f7-4c14-a877-18cde4abb753}"}}`))
+	})
+	defer server.Close()
+
+	repository, err := api.GetRepository()
+	CheckFatal(err, t)
+
+	if want := "/2.0/repositories/morphean-sa/winterfell"; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if repository.FullName != "morphean-sa/winterfell" || repository.Name != "winterfell" || repository.Uuid != "{787fe82b-970a-4349-bae9-8d07306b18cc}" {
+		t.Errorf("unexpected repository %+v", repository)
+	}
+	if repository.Owner.UUID != "{e353c7b3-3723-43f8-b5da-dbddc0d9f8cb}" {
+		t.Errorf("owner = %s", repository.Owner.UUID)
+	}
+	if repository.Project == nil || repository.Project.Key != "WF" {
+		t.Errorf("project = %+v", repository.Project)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+)
+
+// runCommand cascades a branch of a repository from the command line, the way the worker cascades a merged pull
+// request, and fails unless the cascade completed. It is used to replay a cascade which was missed while the service
+// was down or misconfigured:
+//
+//	main run --repo morphean-sa/winterfell --from release/2
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	repo := flags.String("repo", "", "full name of the repository (workspace/slug)")
+	from := flags.String("from", "", "branch the cascade starts from")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	event, err := NewManualEvent(*repo, *from)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(getEnv("CONFIG_FILE", ""))
+	if err != nil {
+		return err
+	}
+
+	workspace, err := newWorkspace()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("cascading %s from %s", *repo, *from)
+	w := NewWorker(nil, NewHealth(), workspace, config)
+	outcome, err := w.Cascade(event)
+	w.deliveries.Wait()
+	if err != nil {
+		return err
+	}
+
+	// the exit status tells whether the cascade completed
+	if outcome == nil {
+		return fmt.Errorf("%s is not cascaded", *from)
+	}
+	if outcome.Failed() {
+		return fmt.Errorf("cascade of %s stopped: %s", *from, errorText(outcome.State))
+	}
+	return nil
+}
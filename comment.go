@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CascadeComment summarises each hop of the cascade in markdown, to be posted on the triggering pull request.
+func CascadeComment(o *CascadeOutcome) string {
+	var b strings.Builder
+	b.WriteString("**Cascade merge**\n\n")
+
+	if o.Origin != nil && o.Origin.PullRequest > 0 && o.PullRequest != nil {
+		fmt.Fprintf(&b, "Resumed the cascade of #%d after merging #%d.\n\n", o.Origin.PullRequest, o.PullRequest.Id)
+	}
+
+	for _, hop := range o.Hops {
+		fmt.Fprintf(&b, "* `%s` → `%s` : ", hop.Source, hop.Target)
+		switch hop.Status {
+		case HopMerged:
+			fmt.Fprintf(&b, "merged (%s)", shortId(hop.Commit))
+		case HopUpToDate:
+			b.WriteString("already up to date")
+		case HopFailed:
+			if o.State.Conflict() != nil {
+				b.WriteString("conflict")
+			} else {
+				fmt.Fprintf(&b, "failed, %s", hop.Error)
+			}
+			if len(o.ConflictURL) > 0 {
+				fmt.Fprintf(&b, ", please solve it in [this pull request](%s)", o.ConflictURL)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if len(o.Hops) == 0 {
+		if o.Failed() {
+			fmt.Fprintf(&b, "The cascade failed: %s\n", errorText(o.State))
+		} else {
+			b.WriteString("Nothing to merge.\n")
+		}
+	}
+
+	return b.String()
+}
+
+func shortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
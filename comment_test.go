@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCascadeComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome *CascadeOutcome
+		want    string
+	}{
+		{
+			name: "Conflict",
+			outcome: &CascadeOutcome{
+				State:       &CascadeMergeState{Source: "release/3", Target: "develop", error: &ConflictError{Paths: []string{"foo"}}},
+				ConflictURL: "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8",
+				Hops: []*CascadeHop{
+					{Source: "release/1", Target: "release/2", Status: HopUpToDate},
+					{Source: "release/2", Target: "release/3", Status: HopMerged, Commit: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"},
+					{Source: "release/3", Target: "develop", Status: HopFailed, Error: "merge resulted in conflicts in foo"},
+				},
+			},
+			want: "**Cascade merge**\n\n" +
+				"* `release/1` → `release/2` : already up to date\n" +
+				"* `release/2` → `release/3` : merged (420b26f53923)\n" +
+				"* `release/3` → `develop` : conflict, please solve it in [this pull request](https://bitbucket.org/morphean-sa/winterfell/pull-requests/8)\n",
+		},
+		{
+			name: "PushFailed",
+			outcome: &CascadeOutcome{
+				State: &CascadeMergeState{Source: "release/3", Target: "develop", error: errors.New("push rejected")},
+				Hops:  []*CascadeHop{{Source: "release/3", Target: "develop", Status: HopFailed, Error: "push rejected"}},
+			},
+			want: "**Cascade merge**\n\n* `release/3` → `develop` : failed, push rejected\n",
+		},
+		{
+			name:    "FetchFailed",
+			outcome: &CascadeOutcome{State: &CascadeMergeState{error: errors.New("authentication required")}},
+			want:    "**Cascade merge**\n\nThe cascade failed: authentication required\n",
+		},
+		{
+			name: "Resumed",
+			outcome: &CascadeOutcome{
+				PullRequest: &PullRequest{Id: 8},
+				Origin:      &CascadeOrigin{PullRequest: 7, Resumes: 1},
+				Hops:        []*CascadeHop{{Source: "release/3", Target: "develop", Status: HopMerged, Commit: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"}},
+			},
+			want: "**Cascade merge**\n\nResumed the cascade of #7 after merging #8.\n\n* `release/3` → `develop` : merged (420b26f53923)\n",
+		},
+		{
+			name:    "Empty",
+			outcome: &CascadeOutcome{},
+			want:    "**Cascade merge**\n\nNothing to merge.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CascadeComment(tt.outcome); got != tt.want {
+				t.Errorf("CascadeComment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config is read from the JSON file given by the CONFIG_FILE environment variable. Each entry of repositories
+// overrides the defaults for the repository matching its key, either a full name (workspace/slug) or a UUID.
+type Config struct {
+	Defaults     RepositoryConfig             `json:"defaults"`
+	Repositories map[string]*RepositoryConfig `json:"-"`
+	// Access restricts the repositories the service works on.
+	Access *AccessConfig `json:"access,omitempty"`
+}
+
+type RepositoryConfig struct {
+	// MergeMessage is a text/template rendered with a MergeMessageData to produce the merge commit messages.
+	MergeMessage string `json:"merge_message,omitempty"`
+	// Committer is the identity of the bot committing the merges, the author of the merged commit is used when empty.
+	Committer *Identity `json:"committer,omitempty"`
+	// Author selects the author of the merge commits : last_commit (default), pull_request or bot.
+	Author AuthorMode `json:"author,omitempty"`
+	// Signing signs the merge commits when set.
+	Signing *SigningConfig `json:"signing,omitempty"`
+	// Merge tunes the merges, see MergeStrategy.
+	Merge *MergeStrategy `json:"merge,omitempty"`
+	// Resolve lists the rules resolving the files still in conflict, the merge fails unless all of them match a rule.
+	Resolve []ResolutionRule `json:"resolve,omitempty"`
+	// Cascade excludes branches from the cascade.
+	Cascade *CascadeRules `json:"cascade,omitempty"`
+	// Notify lists the chat webhooks told about the outcome of the cascades.
+	Notify []NotifierConfig `json:"notify,omitempty"`
+	// Webhooks lists the URLs receiving a JSON document after each cascade.
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+	// Email sends an email to the author of the pull request when the cascade stops on a conflict.
+	Email *EmailConfig `json:"email,omitempty"`
+	// Comment posts the result of each hop of the cascade on the triggering pull request.
+	Comment bool `json:"comment,omitempty"`
+	// BuildStatus publishes a build status on the merge commits when set.
+	BuildStatus *BuildStatusConfig `json:"build_status,omitempty"`
+	// ConflictPullRequest tunes the pull requests opened when the cascade stops on a conflict.
+	ConflictPullRequest *ConflictPullRequestConfig `json:"conflict_pull_request,omitempty"`
+}
+
+type ConflictPullRequestConfig struct {
+	// DefaultReviewers adds the default reviewers of the repository to the author and the approvers of the
+	// triggering pull request.
+	DefaultReviewers bool `json:"default_reviewers,omitempty"`
+	// IntermediateBranch opens the pull request from a copy of the source branch, closed once merged.
+	IntermediateBranch bool `json:"intermediate_branch,omitempty"`
+}
+
+type SigningConfig struct {
+	Format SignatureFormat `json:"format"`
+	// KeyFile is the path of an armored OpenPGP key ring or a PEM encoded SSH private key.
+	KeyFile string `json:"key_file"`
+	// PassphraseEnv is the name of the environment variable holding the passphrase of an encrypted key.
+	PassphraseEnv string `json:"passphrase_env,omitempty"`
+}
+
+type Identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// LoadConfig reads the configuration file at the given path. An empty path gives the default configuration.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Repositories: make(map[string]*RepositoryConfig)}
+	if len(path) == 0 {
+		return config, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw struct {
+		Defaults     json.RawMessage            `json:"defaults"`
+		Repositories map[string]json.RawMessage `json:"repositories"`
+		Access       *AccessConfig              `json:"access"`
+	}
+	err = json.NewDecoder(f).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s : %s", path, err)
+	}
+
+	config.Access = raw.Access
+
+	if len(raw.Defaults) > 0 {
+		err = json.Unmarshal(raw.Defaults, &config.Defaults)
+		if err != nil {
+			return nil, fmt.Errorf("invalid defaults in %s : %s", path, err)
+		}
+	}
+
+	// repositories inherit every setting they do not override from the defaults
+	for key, value := range raw.Repositories {
+		rc, err := config.Defaults.copy()
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(value, rc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository %s in %s : %s", key, path, err)
+		}
+		config.Repositories[normalizeRepositoryKey(key)] = rc
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration %s : %s", path, err)
+	}
+
+	return config, nil
+}
+
+// Validate checks every repository configuration can be used.
+func (c *Config) Validate() error {
+	err := c.Defaults.Validate()
+	if err != nil {
+		return fmt.Errorf("defaults: %s", err)
+	}
+	if c.Access != nil {
+		err = c.Access.Validate()
+		if err != nil {
+			return fmt.Errorf("access: %s", err)
+		}
+	}
+	for key, rc := range c.Repositories {
+		err = rc.Validate()
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// Repository returns the configuration of the given repository, looked up by UUID then by full name.
+func (c *Config) Repository(r *Repository) *RepositoryConfig {
+	if r != nil {
+		for _, key := range []string{r.Uuid, r.FullName} {
+			if rc, ok := c.Repositories[normalizeRepositoryKey(key)]; ok && len(key) > 0 {
+				return rc
+			}
+		}
+	}
+	return &c.Defaults
+}
+
+func (rc *RepositoryConfig) Validate() error {
+	_, err := NewMergeMessageTemplate(rc.MergeMessage)
+	if err != nil {
+		return fmt.Errorf("merge_message: %s", err)
+	}
+	if c := rc.Committer; c != nil && (len(c.Name) == 0 || len(c.Email) == 0) {
+		return errors.New("committer: name and email are required")
+	}
+	err = rc.Author.Validate()
+	if err != nil {
+		return fmt.Errorf("author: %s", err)
+	}
+	if rc.Author == AuthorBot && rc.Committer == nil {
+		return errors.New("author: bot requires a committer")
+	}
+	if rc.Signing != nil {
+		_, err = rc.Signing.Signer()
+		if err != nil {
+			return fmt.Errorf("signing: %s", err)
+		}
+	}
+	if rc.Merge != nil {
+		err = rc.Merge.Validate()
+		if err != nil {
+			return fmt.Errorf("merge: %s", err)
+		}
+	}
+	for _, rule := range rc.Resolve {
+		err = rule.Validate()
+		if err != nil {
+			return fmt.Errorf("resolve: %s", err)
+		}
+	}
+	if rc.Cascade != nil {
+		err = rc.Cascade.Validate()
+		if err != nil {
+			return fmt.Errorf("cascade: %s", err)
+		}
+	}
+	for _, nc := range rc.Notify {
+		err = nc.Validate()
+		if err != nil {
+			return fmt.Errorf("notify: %s", err)
+		}
+	}
+	for _, wc := range rc.Webhooks {
+		err = wc.Validate()
+		if err != nil {
+			return fmt.Errorf("webhooks: %s", err)
+		}
+	}
+	if rc.Email != nil {
+		err = rc.Email.Validate()
+		if err != nil {
+			return fmt.Errorf("email: %s", err)
+		}
+	}
+	return nil
+}
+
+// Deep copy through JSON, configurations only hold JSON serializable values.
+func (rc *RepositoryConfig) copy() (*RepositoryConfig, error) {
+	data, err := json.Marshal(rc)
+	if err != nil {
+		return nil, err
+	}
+	c := &RepositoryConfig{}
+	err = json.Unmarshal(data, c)
+	return c, err
+}
+
+// CommitterAuthor returns the bot identity as expected by the git client, or nil when no committer is configured.
+func (rc *RepositoryConfig) CommitterAuthor() *Author {
+	if rc.Committer == nil {
+		return nil
+	}
+	return &Author{Name: rc.Committer.Name, Email: rc.Committer.Email}
+}
+
+// Signer loads the signing key.
+func (sc *SigningConfig) Signer() (CommitSigner, error) {
+	passphrase := ""
+	if len(sc.PassphraseEnv) > 0 {
+		passphrase = os.Getenv(sc.PassphraseEnv)
+	}
+	return NewCommitSigner(sc.Format, sc.KeyFile, passphrase)
+}
+
+// Full names are case insensitive on Bitbucket, UUIDs may be given with or without braces.
+func normalizeRepositoryKey(key string) string {
+	return strings.ToLower(strings.Trim(key, "{}"))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(`{
+  "defaults": {"merge_message": "Merge {{.Source}} into {{.Target}}"},
+  "repositories": {
+    "Morphean-SA/Winterfell": {"merge_message": "Merge {{.Source}} into {{.Target}} [skip ci]"},
+    "{787fe82b-970a-4349-bae9-8d07306b18cc}": {}
+  }
+}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadConfig(path)
+	CheckFatal(err, t)
+
+	tests := []struct {
+		name       string
+		repository *Repository
+		want       string
+	}{
+		{name: "FullName", repository: &Repository{FullName: "morphean-sa/winterfell"}, want: "Merge {{.Source}} into {{.Target}} [skip ci]"},
+		{name: "InheritDefaults", repository: &Repository{Uuid: "{787fe82b-970a-4349-bae9-8d07306b18cc}"}, want: "Merge {{.Source}} into {{.Target}}"},
+		{name: "Unknown", repository: &Repository{FullName: "morphean-sa/kings-landing"}, want: "Merge {{.Source}} into {{.Target}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.Repository(tt.repository).MergeMessage; got != tt.want {
+				t.Errorf("MergeMessage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The merge message template cannot be parsed. We expect the configuration to be rejected.
+func TestLoadConfig_Invalid(t *testing.T) {
+	path := writeConfig(`{"repositories": {"morphean-sa/winterfell": {"merge_message": "{{.Source"}}}`, t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Error("invalid template must be rejected")
+	}
+}
+
+func TestLoadConfig_Empty(t *testing.T) {
+	config, err := LoadConfig("")
+	CheckFatal(err, t)
+
+	if config.Repository(&Repository{FullName: "morphean-sa/winterfell"}) != &config.Defaults {
+		t.Error("defaults must be used without configuration file")
+	}
+}
+
+func writeConfig(content string, t *testing.T) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "cascade-config-")
+	CheckFatal(err, t)
+	path := filepath.Join(dir, "config.json")
+	CheckFatal(ioutil.WriteFile(path, []byte(content), 0644), t)
+	return path
+}
+
+func TestRepositoryConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RepositoryConfig
+		wantErr bool
+	}{
+		{name: "Empty", config: RepositoryConfig{}},
+		{name: "Bot", config: RepositoryConfig{Committer: &Identity{Name: "Cascade Bot", Email: "bot@winterfell.net"}, Author: AuthorBot}},
+		{name: "BotWithoutCommitter", config: RepositoryConfig{Author: AuthorBot}, wantErr: true},
+		{name: "IncompleteCommitter", config: RepositoryConfig{Committer: &Identity{Name: "Cascade Bot"}}, wantErr: true},
+		{name: "UnknownAuthor", config: RepositoryConfig{Author: "reviewer"}, wantErr: true},
+		{name: "MergeStrategy", config: RepositoryConfig{Merge: &MergeStrategy{RenameThreshold: 60, Favor: []FavorRule{{Path: "CHANGELOG.md", Favor: FavorUnion}}}}},
+		{name: "UnknownFavor", config: RepositoryConfig{Merge: &MergeStrategy{Favor: []FavorRule{{Path: "go.sum", Favor: "mine"}}}}, wantErr: true},
+		{name: "InvalidFavorPath", config: RepositoryConfig{Merge: &MergeStrategy{Favor: []FavorRule{{Path: "[", Favor: FavorOurs}}}}, wantErr: true},
+		{name: "InvalidRenameThreshold", config: RepositoryConfig{Merge: &MergeStrategy{RenameThreshold: 101}}, wantErr: true},
+		{name: "CascadeRules", config: RepositoryConfig{Cascade: &CascadeRules{Exclude: []string{"release/0.*"}, InactiveDays: 180, StopAt: "release/9"}}},
+		{name: "InvalidExcludeRegex", config: RepositoryConfig{Cascade: &CascadeRules{ExcludeRegex: []string{"release/(1"}}}, wantErr: true},
+		{name: "NegativeInactiveDays", config: RepositoryConfig{Cascade: &CascadeRules{InactiveDays: -1}}, wantErr: true},
+		{name: "MissingSigningKey", config: RepositoryConfig{Signing: &SigningConfig{Format: SignatureSSH, KeyFile: "/nonexistent/id_ed25519"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
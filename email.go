@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const DefaultEmailSubject = "[{{.Repository}}] Cascade of #{{.PullRequest.Id}} stopped on {{.Target}}"
+
+const DefaultEmailBody = `Hello {{.Author}},
+
+The cascade of your pull request #{{.PullRequest.Id}} {{.PullRequest.Title}} stopped: {{.Source}} cannot be merged into {{.Target}}.
+{{if .Files}}
+Conflicting files:
+{{range .Files}}  {{.}}
+{{end}}{{end}}
+{{if .ConflictURL}}Please solve the conflicts in {{.ConflictURL}}{{else}}Please solve the conflicts and merge {{.Source}} into {{.Target}}.{{end}}
+`
+
+type EmailConfig struct {
+	// Subject and Template are text/template rendered with an EmailData.
+	Subject  string `json:"subject,omitempty"`
+	Template string `json:"template,omitempty"`
+	// Addresses maps Bitbucket account ids, UUIDs or nicknames to email addresses, the email of the head commit of
+	// the pull request is used otherwise.
+	Addresses map[string]string `json:"addresses,omitempty"`
+}
+
+func (ec *EmailConfig) Validate() error {
+	_, _, err := ec.templates()
+	return err
+}
+
+func (ec *EmailConfig) templates() (*template.Template, *template.Template, error) {
+	subject, body := ec.Subject, ec.Template
+	if len(strings.TrimSpace(subject)) == 0 {
+		subject = DefaultEmailSubject
+	}
+	if len(strings.TrimSpace(body)) == 0 {
+		body = DefaultEmailBody
+	}
+	s, err := template.New("subject").Option("missingkey=zero").Parse(subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("subject: %s", err)
+	}
+	b, err := template.New("body").Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("template: %s", err)
+	}
+	return s, b, nil
+}
+
+// Address returns the configured address of the first key found, or an empty string.
+func (ec *EmailConfig) Address(keys ...string) string {
+	for _, key := range keys {
+		if address, ok := ec.Addresses[key]; ok && len(key) > 0 {
+			return address
+		}
+	}
+	return ""
+}
+
+// Notifier returns an email notifier sending through the SMTP server configured in the environment.
+func (ec *EmailConfig) Notifier() (*EmailNotifier, error) {
+	subject, body, err := ec.templates()
+	if err != nil {
+		return nil, err
+	}
+	return &EmailNotifier{
+		Addr:     net.JoinHostPort(getEnv("SMTP_HOST", "localhost"), getEnv("SMTP_PORT", "25")),
+		Username: getEnv("SMTP_USERNAME", ""),
+		Password: getEnv("SMTP_PASSWORD", ""),
+		From:     getEnv("SMTP_FROM", "cascade-merge@localhost"),
+		Subject:  subject,
+		Body:     body,
+		Timeout:  getEnvDuration("SMTP_TIMEOUT", 30*time.Second),
+	}, nil
+}
+
+// EmailData is given to the email templates.
+type EmailData struct {
+	Repository  string
+	PullRequest *PullRequest
+	Author      string
+	Source      string
+	Target      string
+	Files       []string
+	ConflictURL string
+}
+
+// EmailNotifier emails the author of the triggering pull request when the cascade stops on a conflict.
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	Subject  *template.Template
+	Body     *template.Template
+	// Timeout bounds the whole SMTP session, an unreachable server must not hold the worker.
+	Timeout time.Duration
+}
+
+func (n *EmailNotifier) Notify(o *CascadeOutcome) error {
+	conflict := o.State.Conflict()
+	if conflict == nil || o.PullRequest == nil || o.PullRequest.Manual() {
+		return nil
+	}
+	if len(o.AuthorEmail) == 0 {
+		return errors.New("no email address for the author of the pull request")
+	}
+
+	data := &EmailData{
+		Repository:  o.Repository.FullName,
+		PullRequest: o.PullRequest,
+		Source:      o.State.Source,
+		Target:      o.State.Target,
+		Files:       conflict.Paths,
+		ConflictURL: o.ConflictURL,
+	}
+	if len(data.Repository) == 0 {
+		data.Repository = o.Repository.Name
+	}
+	if o.PullRequest.Author != nil {
+		data.Author = o.PullRequest.Author.DisplayName
+	}
+
+	var subject, body bytes.Buffer
+	if err := n.Subject.Execute(&subject, data); err != nil {
+		return err
+	}
+	if err := n.Body.Execute(&body, data); err != nil {
+		return err
+	}
+
+	return n.send(o.AuthorEmail, subject.String(), body.String())
+}
+
+func (n *EmailNotifier) send(to string, subject string, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return n.sendMail(to, msg.Bytes())
+}
+
+// Same as smtp.SendMail with a deadline on the connection.
+func (n *EmailNotifier) sendMail(to string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", n.Addr, n.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if n.Timeout > 0 {
+		err = conn.SetDeadline(time.Now().Add(n.Timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	host, _, _ := net.SplitHostPort(n.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if len(n.Username) > 0 {
+		err = c.Auth(smtp.PlainAuth("", n.Username, n.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(n.From); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// A stub SMTP server accepting a single message, its recipients and data are sent to the returned channel.
+func stubSMTP(t *testing.T) (string, <-chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	CheckFatal(err, t)
+
+	messages := make(chan []string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost stub")
+		var message []string
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL":
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				message = append(message, line)
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 end with <CRLF>.<CRLF>")
+				data, err := tp.ReadDotLines()
+				if err != nil {
+					return
+				}
+				message = append(message, data...)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				messages <- message
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func conflictEmailOutcome() *CascadeOutcome {
+	outcome := conflictOutcome()
+	outcome.AuthorEmail = "arya.stark@winterfell.net"
+	return outcome
+}
+
+// The cascade stopped on a conflict. We expect the author to receive the conflicting files and the conflict link.
+func TestEmailNotifier_Notify(t *testing.T) {
+	addr, messages := stubSMTP(t)
+
+	n, err := (&EmailConfig{}).Notifier()
+	CheckFatal(err, t)
+	n.Addr = addr
+	n.From = "cascade@winterfell.net"
+
+	CheckFatal(n.Notify(conflictEmailOutcome()), t)
+
+	message := strings.Join(<-messages, "\n")
+	for _, want := range []string{
+		"RCPT TO:<arya.stark@winterfell.net>",
+		"To: arya.stark@winterfell.net",
+		"Subject: [morphean-sa/winterfell] Cascade of #7 stopped on release/3",
+		"Hello Arya Stark,",
+		"  foo\n  bar/baz.go",
+		"https://bitbucket.org/morphean-sa/winterfell/pull-requests/8",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message does not contain %q:\n%s", want, message)
+		}
+	}
+}
+
+// The SMTP server accepts the connection but never answers. We expect the notifier to give up after the timeout.
+func TestEmailNotifier_Timeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	CheckFatal(err, t)
+	defer listener.Close()
+
+	n, err := (&EmailConfig{}).Notifier()
+	CheckFatal(err, t)
+	n.Addr = listener.Addr().String()
+	n.Timeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		done <- n.Notify(conflictEmailOutcome())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected a timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifier is blocked by a stalled server")
+	}
+}
+
+// The cascade failed for another reason than a conflict. We expect no email.
+func TestEmailNotifier_NotAConflict(t *testing.T) {
+	n := &EmailNotifier{Addr: "127.0.0.1:1", Subject: template.Must(template.New("").Parse("")), Body: template.Must(template.New("").Parse(""))}
+
+	outcome := conflictEmailOutcome()
+	outcome.State.error = errors.New("authentication required")
+	CheckFatal(n.Notify(outcome), t)
+
+	outcome.State = nil
+	CheckFatal(n.Notify(outcome), t)
+}
+
+func TestEmailConfig_Address(t *testing.T) {
+	ec := &EmailConfig{Addresses: map[string]string{"5e3c1d7ae2a2820c950d6381": "arya.stark@winterfell.net"}}
+
+	if got := ec.Address("", "{572f862f}", "5e3c1d7ae2a2820c950d6381"); got != "arya.stark@winterfell.net" {
+		t.Errorf("Address() = %q", got)
+	}
+	if got := ec.Address("unknown"); got != "" {
+		t.Errorf("Address() = %q, want empty", got)
+	}
+}
+
+func TestEmailConfig_Validate(t *testing.T) {
+	if err := (&EmailConfig{Template: "{{.Source"}).Validate(); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+	if err := (&EmailConfig{Subject: "Conflict on {{.Target}}"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,7 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/libgit2/git2go/v34"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -12,13 +17,23 @@ const (
 	DefaultMaster                = "master"
 	DefaultRemoteName            = "origin"
 	DefaultRemoteReferencePrefix = "refs/heads/"
+	DefaultRemoteTrackingPrefix  = "refs/remotes/"
 	DefaultCommitReferenceName   = "HEAD"
 )
 
 type Client struct {
 	Repository      *git.Repository
 	RemoteCallbacks git.RemoteCallbacks
-	Author          *Author
+	// Author is the identity of the bot, it commits the merges when set.
+	Author       *Author
+	AuthorMode   AuthorMode
+	MergeMessage *template.Template
+	// Signer signs the merge commits when set.
+	Signer CommitSigner
+	// Strategy tunes the merges, conflicts are not resolved when nil.
+	Strategy *MergeStrategy
+	// ResolutionRules resolve the files still in conflict after the merge strategy.
+	ResolutionRules []ResolutionRule
 }
 
 type Credentials struct {
@@ -27,10 +42,19 @@ type Credentials struct {
 }
 
 type ClientOptions struct {
-	Path        string
-	URL         string
-	Author      *Author
-	Credentials *Credentials
+	Path string
+	URL  string
+	Bare bool
+	// Refspecs restricts the initial clone to the given fetch refspecs, the whole repository is cloned when empty.
+	// Depth and object filters are not available with libgit2 v1.5, the history of the fetched branches is complete.
+	Refspecs        []string
+	Author          *Author
+	AuthorMode      AuthorMode
+	Credentials     *Credentials
+	MergeMessage    *template.Template
+	Signer          CommitSigner
+	Strategy        *MergeStrategy
+	ResolutionRules []ResolutionRule
 }
 
 func (c *Client) CascadeMerge(branchName string, options *CascadeOptions) *CascadeMergeState {
@@ -42,12 +66,7 @@ func (c *Client) CascadeMerge(branchName string, options *CascadeOptions) *Casca
 		}
 	}
 
-	err := c.RemoveLocalBranches()
-	if err != nil {
-		return &CascadeMergeState{error: err}
-	}
-
-	err = c.Fetch()
+	err := c.Fetch(options)
 	if err != nil {
 		return &CascadeMergeState{error: err}
 	}
@@ -59,41 +78,54 @@ func (c *Client) CascadeMerge(branchName string, options *CascadeOptions) *Casca
 
 	source := branchName
 
-	err = c.Checkout(source)
-	if err != nil {
-		return &CascadeMergeState{error: err}
-	}
-
-	err = c.Reset(source)
-	if err != nil {
-		return &CascadeMergeState{error: err}
-	}
-
 	for target := cascade.Next(); target != ""; target = cascade.Next() {
-		err = c.Checkout(target)
+		oid, err := c.mergeAndPush(source, target, options.PullRequest)
+		reportHop(options, source, target, oid, err)
 		if err != nil {
 			return &CascadeMergeState{Source: source, Target: target, error: err}
 		}
+		source = target
+	}
 
-		err = c.Reset(target)
-		if err != nil {
-			return &CascadeMergeState{Source: source, Target: target, error: err}
-		}
+	return nil
+}
 
-		err = c.MergeBranches(source, target)
-		if err != nil {
-			return &CascadeMergeState{Source: source, Target: target, error: err}
-		}
+func reportHop(options *CascadeOptions, source string, target string, oid *git.Oid, err error) {
+	if options.OnHop == nil {
+		return
+	}
+	hop := &CascadeHop{Source: source, Target: target, Status: HopUpToDate}
+	if err != nil {
+		hop.Status = HopFailed
+		hop.Error = err.Error()
+	} else if oid != nil {
+		hop.Status = HopMerged
+		hop.Commit = oid.String()
+	}
+	options.OnHop(hop)
+}
 
-		err := c.Push(target)
-		if err != nil {
-			return &CascadeMergeState{Source: source, Target: target, error: err}
-		}
+// Merge the source branch into the target branch and push the merge commit, nil is returned when the target branch
+// is already up to date.
+func (c *Client) mergeAndPush(source string, target string, trigger *PullRequest) (*git.Oid, error) {
+	oid, err := c.MergeBranches(source, target, trigger)
+	if err != nil || oid == nil {
+		return nil, err
+	}
 
-		source = target
+	err = c.Push(target)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// the next merge reads the pushed commit from the remote branch
+	ref, err := c.Repository.References.Create(DefaultRemoteTrackingPrefix+DefaultRemoteName+"/"+target, oid, true, "cascade: push")
+	if err != nil {
+		return nil, err
+	}
+	ref.Free()
+
+	return oid, nil
 }
 
 func (c *Client) Commit(message string, path ...string) (*git.Oid, error) {
@@ -238,38 +270,51 @@ func (c *Client) Push(branchName string) error {
 	return nil
 }
 
-func (c *Client) Fetch() error {
-	remote, err := c.Repository.Remotes.Lookup(DefaultRemoteName)
+// IntermediateBranchName names the copy of the source branch of a conflict pull request.
+func IntermediateBranchName(source string, target string) string {
+	return "cascade/" + strings.ReplaceAll(source, "/", "-") + "-into-" + strings.ReplaceAll(target, "/", "-")
+}
+
+// CreateIntermediateBranch pushes a branch pointing to the head of the remote source branch, replacing any previous
+// branch of that name.
+func (c *Client) CreateIntermediateBranch(source string, name string) error {
+	commit, err := c.LookupRemoteCommit(source)
 	if err != nil {
 		return err
 	}
-	defer remote.Free()
+	defer commit.Free()
 
-	var refs []string
-	err = remote.Fetch(refs, &git.FetchOptions{RemoteCallbacks: c.RemoteCallbacks, Prune: git.FetchPruneOn}, "")
+	ref, err := c.Repository.References.Create(DefaultRemoteReferencePrefix+name, commit.Id(), true, "cascade: intermediate branch")
+	if err != nil {
+		return err
+	}
+	ref.Free()
 
+	remote, err := c.Repository.Remotes.Lookup(DefaultRemoteName)
 	if err != nil {
 		return err
 	}
+	defer remote.Free()
 
-	return nil
+	refspec := "+" + DefaultRemoteReferencePrefix + name + ":" + DefaultRemoteReferencePrefix + name
+	return remote.Push([]string{refspec}, &git.PushOptions{RemoteCallbacks: c.RemoteCallbacks})
 }
 
-// Reset current HEAD to the remote branch
-func (c *Client) Reset(branchName string) error {
-	branch, err := c.Repository.LookupBranch(fmt.Sprintf("%s/%s", DefaultRemoteName, branchName), git.BranchRemote)
+// Fetch the branches taking part in the cascade, or every branch of the remote when the options ask for a full fetch.
+func (c *Client) Fetch(options *CascadeOptions) error {
+	remote, err := c.Repository.Remotes.Lookup(DefaultRemoteName)
 	if err != nil {
 		return err
 	}
-	defer branch.Free()
+	defer remote.Free()
 
-	commit, err := c.Repository.LookupCommit(branch.Target())
-	if err != nil {
-		return err
+	refs := []string{fmt.Sprintf("+refs/heads/*:%s%s/*", DefaultRemoteTrackingPrefix, DefaultRemoteName)}
+	if options != nil && !options.FullFetch {
+		refs = options.Refspecs(DefaultRemoteName)
 	}
-	defer commit.Free()
 
-	err = c.Repository.ResetToCommit(commit, git.ResetHard, &git.CheckoutOpts{})
+	err = remote.Fetch(refs, &git.FetchOptions{RemoteCallbacks: c.RemoteCallbacks, Prune: git.FetchPruneOn}, "")
+
 	if err != nil {
 		return err
 	}
@@ -288,150 +333,281 @@ func (c *Client) BuildCascade(options *CascadeOptions, startBranch string) (*Cas
 		return nil, err
 	}
 
-	iterator.ForEach(func(branch *git.Branch, branchType git.BranchType) error {
+	now := time.Now()
+	err = iterator.ForEach(func(branch *git.Branch, branchType git.BranchType) error {
 		shorthand := branch.Shorthand()
 		branchName := strings.TrimPrefix(shorthand, DefaultRemoteName+"/")
-		if branchName == options.DevelopmentName || strings.HasPrefix(branchName, options.ReleasePrefix) {
-			cascade.Append(branchName)
+		if branchName != options.DevelopmentName && !strings.HasPrefix(branchName, options.ReleasePrefix) {
+			return nil
+		}
+		if options.Rules.Excluded(branchName) {
+			return nil
+		}
+		if options.Rules != nil && options.Rules.InactiveDays > 0 && branch.Target() != nil {
+			commit, err := c.Repository.LookupCommit(branch.Target())
+			if err != nil {
+				return err
+			}
+			inactive := options.Rules.Inactive(commit.Committer().When, now)
+			commit.Free()
+			if inactive {
+				return nil
+			}
 		}
+		cascade.Append(branchName)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	cascade.Slice(startBranch)
 
+	if options.Rules != nil && len(options.Rules.StopAt) > 0 {
+		cascade.StopAt(options.Rules.StopAt)
+	}
+
+	// an unknown branch stops the cascade right away rather than merging past the intended branch
+	if len(options.Until) > 0 && !cascade.Until(options.Until) {
+		log.Printf("branch %s to cascade until is not part of the cascade, nothing to merge", options.Until)
+		cascade.Branches = cascade.Branches[:0]
+	}
+
 	return &cascade, nil
 }
 
-func (c *Client) MergeBranches(sourceBranchName string, destinationBranchName string) error {
-	// assuming that these two branches are local already
-	sourceBranch, err := c.Repository.LookupBranch(sourceBranchName, git.BranchLocal)
+// MergeBranches merges the remote source branch into the remote destination branch without using the working
+// directory. The merge commit is written to the local destination branch and its id is returned, or nil when the
+// destination branch already contains the source branch. The pull request which triggered the cascade, if any, is
+// given to the merge message template.
+func (c *Client) MergeBranches(sourceBranchName string, destinationBranchName string, trigger *PullRequest) (*git.Oid, error) {
+	sourceCommit, err := c.LookupRemoteCommit(sourceBranchName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer sourceBranch.Free()
+	defer sourceCommit.Free()
 
-	destinationBranch, err := c.Repository.LookupBranch(destinationBranchName, git.BranchLocal)
+	destinationCommit, err := c.LookupRemoteCommit(destinationBranchName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer destinationBranch.Free()
+	defer destinationCommit.Free()
 
-	// assuming we are already checkout as the destination branch
-	sourceAnnCommit, err := c.Repository.AnnotatedCommitFromRef(sourceBranch.Reference)
+	// branches are already merged?
+	if sourceCommit.Id().Equal(destinationCommit.Id()) {
+		return nil, nil
+	}
+	merged, err := c.Repository.DescendantOf(destinationCommit.Id(), sourceCommit.Id())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if merged {
+		return nil, nil
 	}
-	defer sourceAnnCommit.Free()
 
-	// getting repo head
-	head, err := c.Repository.Head()
+	// options for merge
+	mergeOpts, err := c.Strategy.MergeOptions()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// do merge analysis
-	mergeHeads := make([]*git.AnnotatedCommit, 1)
-	mergeHeads[0] = sourceAnnCommit
-	analysis, _, err := c.Repository.MergeAnalysis(mergeHeads)
-
-	// branches are already merged?
-	if analysis&git.MergeAnalysisNone != 0 || analysis&git.MergeAnalysisUpToDate != 0 {
-		return nil
+	// merge action, computed in memory
+	index, err := c.Repository.MergeCommits(destinationCommit, sourceCommit, mergeOpts)
+	if err != nil {
+		return nil, err
 	}
+	defer index.Free()
 
-	// should merge
-	if analysis&git.MergeAnalysisNormal == 0 {
-		return errors.New("merge analysis returned as not normal merge")
+	// checking for conflicts the strategy and the resolution rules cannot resolve
+	var resolutions []*ConflictResolution
+	if index.HasConflicts() {
+		unresolved, err := c.ResolveConflicts(index, c.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		if len(unresolved) > 0 {
+			resolutions, err = c.ApplyResolutionRules(index, unresolved, c.ResolutionRules)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// options for merge
-	mergeOpts, _ := git.DefaultMergeOptions()
-	mergeOpts.FileFavor = git.MergeFileFavorNormal
-	mergeOpts.TreeFlags = git.MergeTreeFailOnConflict
+	// getting signatures
+	author, committer := c.MergeSignatures(sourceCommit, trigger)
 
-	// options for checkout
-	checkoutOpts := &git.CheckoutOpts{
-		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing | git.CheckoutUseTheirs,
+	// writing the merged index as a tree
+	treeId, err := index.WriteTreeTo(c.Repository)
+	if err != nil {
+		return nil, err
 	}
 
-	// merge action
-	if err = c.Repository.Merge(mergeHeads, &mergeOpts, checkoutOpts); err != nil {
-		return err
+	// getting the created tree
+	tree, err := c.Repository.LookupTree(treeId)
+	if err != nil {
+		return nil, err
 	}
+	defer tree.Free()
 
-	// getting repo index
-	index, err := c.Repository.Index()
+	// render the commit message
+	commits, err := c.MergedCommits(sourceCommit, destinationCommit)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer index.Free()
 
-	// checking for conflicts
-	if index.HasConflicts() {
-		return errors.New("merge resulted in conflicts, please solve the conflicts before merging")
+	data := &MergeMessageData{
+		Source:      sourceBranchName,
+		Target:      destinationBranchName,
+		PullRequest: trigger,
+		Commits:     commits,
+		Resolutions: resolutions,
+	}
+	if trigger != nil {
+		data.JiraKeys = extractJiraKeys(trigger.Title, trigger.Description, trigger.SourceBranchName())
 	}
 
-	// getting last commit from source
-	commit, err := c.Repository.LookupCommit(sourceBranch.Target())
+	message, err := data.Render(c.MergeMessage)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("cannot render merge message: %s", err)
 	}
-	defer commit.Free()
 
-	// getting signature
-	signature := commit.Author()
+	// commit without updating any reference
+	oid, err := c.CreateCommit(author, committer, message, tree, destinationCommit, sourceCommit)
+	if err != nil {
+		return nil, err
+	}
 
-	// writing tree to index
-	treeId, err := index.WriteTree()
+	// point the local destination branch to the merge commit to push it
+	ref, err := c.Repository.References.Create(DefaultRemoteReferencePrefix+destinationBranchName, oid, true, "cascade: "+sourceBranchName)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	ref.Free()
 
-	// getting the created tree
-	tree, err := c.Repository.LookupTree(treeId)
+	return oid, nil
+}
+
+// CreateCommit writes a commit object, signed when the client has a signer, without updating any reference.
+func (c *Client) CreateCommit(author, committer *git.Signature, message string, tree *git.Tree, parents ...*git.Commit) (*git.Oid, error) {
+	if c.Signer == nil {
+		return c.Repository.CreateCommit("", author, committer, message, tree, parents...)
+	}
+
+	content, err := c.Repository.CreateCommitBuffer(author, committer, git.MessageEncodingUTF8, message, tree, parents...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tree.Free()
 
-	// getting head's commit
-	currentDestinationCommit, err := c.Repository.LookupCommit(head.Target())
+	signature, err := c.Signer.Sign(content)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("cannot sign commit: %s", err)
+	}
+
+	return c.Repository.CreateCommitWithSignature(string(content), signature, "")
+}
+
+// MergeSignatures returns the author and the committer of a merge of the given source commit. The committer is the
+// bot when the client has an identity, the author depends on the author mode and falls back to the author of the
+// source commit.
+func (c *Client) MergeSignatures(source *git.Commit, trigger *PullRequest) (*git.Signature, *git.Signature) {
+	var bot *git.Signature
+	if c.Author != nil && len(c.Author.Name) > 0 && len(c.Author.Email) > 0 {
+		bot = &git.Signature{Name: c.Author.Name, Email: c.Author.Email, When: time.Now()}
+	}
+
+	author := source.Author()
+	switch c.AuthorMode {
+	case AuthorBot:
+		if bot != nil {
+			author = bot
+		}
+	case AuthorPullRequest:
+		if s := c.pullRequestSignature(trigger); s != nil {
+			author = s
+		}
+	}
+
+	if bot != nil {
+		return author, bot
+	}
+	return author, author
+}
+
+// Read the identity of the author of the head commit of the given pull request.
+func (c *Client) pullRequestSignature(pr *PullRequest) *git.Signature {
+	if pr == nil || pr.Source == nil || pr.Source.Commit == nil || len(pr.Source.Commit.Hash) == 0 {
+		return nil
 	}
 
-	// commit
-	_, err = c.Repository.CreateCommit(DefaultCommitReferenceName, signature, signature, "Automatic merge "+sourceBranchName+" into "+destinationBranchName,
-		tree, currentDestinationCommit, commit)
+	object, err := c.Repository.RevparseSingle(pr.Source.Commit.Hash)
 	if err != nil {
-		return err
+		return nil
 	}
+	defer object.Free()
 
-	err = c.Repository.StateCleanup()
+	commit, err := object.AsCommit()
 	if err != nil {
-		return err
+		return nil
 	}
+	defer commit.Free()
 
-	return nil
+	return &git.Signature{Name: commit.Author().Name, Email: commit.Author().Email, When: time.Now()}
+}
+
+// PullRequestAuthorEmail returns the email of the author of the head commit of the pull request, or an empty string
+// when the commit is not available.
+func (c *Client) PullRequestAuthorEmail(pr *PullRequest) string {
+	signature := c.pullRequestSignature(pr)
+	if signature == nil {
+		return ""
+	}
+	return signature.Email
 }
 
-func (c *Client) RemoveLocalBranches() error {
-	iterator, err := c.Repository.NewBranchIterator(git.BranchLocal)
+// MergedCommits lists the commits of source which are not part of destination, the most recent first.
+func (c *Client) MergedCommits(source *git.Commit, destination *git.Commit) ([]*MergedCommit, error) {
+	walk, err := c.Repository.Walk()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer walk.Free()
 
-	iterator.ForEach(func(branch *git.Branch, branchType git.BranchType) error {
-		if DefaultMaster != branch.Shorthand() {
-			err = branch.Delete()
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+	walk.Sorting(git.SortTopological | git.SortTime)
+
+	err = walk.Push(source.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	err = walk.Hide(destination.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]*MergedCommit, 0)
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		id := commit.Id().String()
+		commits = append(commits, &MergedCommit{
+			Id:      id,
+			ShortId: id[:12],
+			Summary: commit.Summary(),
+			Author:  commit.Author().Name,
+		})
+		return true
 	})
 
-	return nil
+	return commits, err
+}
+
+// LookupRemoteCommit returns the commit of the remote branch with the given name.
+func (c *Client) LookupRemoteCommit(branchName string) (*git.Commit, error) {
+	branch, err := c.Repository.LookupBranch(DefaultRemoteName+"/"+branchName, git.BranchRemote)
+	if err != nil {
+		return nil, err
+	}
+	defer branch.Free()
+
+	return c.Repository.LookupCommit(branch.Target())
 }
 
 func (c *Client) Close() {
@@ -451,12 +627,37 @@ func NewClient(options *ClientOptions) (*Client, error) {
 	// try to open an existing repository
 	r, err = git.OpenRepository(options.Path)
 
+	// a previous run may have crashed while working on this repository
+	if err == nil {
+		repairs, rerr := RepairRepository(r)
+		for _, repair := range repairs {
+			log.Printf("repaired %s: %s", options.Path, repair)
+		}
+		if rerr != nil {
+			log.Printf("cannot repair %s, cloning again: %s", options.Path, rerr)
+			r.Free()
+			err = rerr
+		}
+	}
+
 	// create fetch options (credentials callback)
 	cb = options.CreateRemoteCallbacks()
 
 	if err != nil {
+		// discard the leftovers of a broken repository, bare or not, clone requires an empty directory
+		if _, serr := os.Stat(options.Path); serr == nil {
+			err = os.RemoveAll(options.Path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot remove broken repository at %s : %s", options.Path, err)
+			}
+		}
+
 		// try clone the given url with the given credentials
-		r, err = git.Clone(options.URL, options.Path, &git.CloneOptions{FetchOptions: git.FetchOptions{RemoteCallbacks: cb}})
+		if len(options.Refspecs) > 0 {
+			r, err = cloneRefspecs(options, cb)
+		} else {
+			r, err = git.Clone(options.URL, options.Path, &git.CloneOptions{FetchOptions: git.FetchOptions{RemoteCallbacks: cb}, Bare: options.Bare})
+		}
 		if err != nil {
 			return nil, fmt.Errorf("cannot initialize repository at %s : %s", options.URL, err)
 		}
@@ -470,10 +671,57 @@ func NewClient(options *ClientOptions) (*Client, error) {
 		Repository:      r,
 		RemoteCallbacks: cb,
 		Author:          options.Author,
+		AuthorMode:      options.AuthorMode,
+		MergeMessage:    options.MergeMessage,
+		Signer:          options.Signer,
+		Strategy:        options.Strategy,
+		ResolutionRules: options.ResolutionRules,
 	}, nil
 
 }
 
+// Initialize a repository with a remote configured to fetch the given refspecs only and fetch it.
+func cloneRefspecs(options *ClientOptions, cb git.RemoteCallbacks) (*git.Repository, error) {
+	r, err := git.InitRepository(options.Path, options.Bare)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fetchRefspecs(r, options, cb)
+	if err != nil {
+		// do not leave a partially fetched repository behind
+		r.Free()
+		os.RemoveAll(options.Path)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func fetchRefspecs(r *git.Repository, options *ClientOptions, cb git.RemoteCallbacks) error {
+	remote, err := r.Remotes.CreateWithFetchspec(DefaultRemoteName, options.URL, options.Refspecs[0])
+	if err != nil {
+		return err
+	}
+	remote.Free()
+
+	for _, refspec := range options.Refspecs[1:] {
+		err = r.Remotes.AddFetch(DefaultRemoteName, refspec)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the remote is looked up again to take the added refspecs into account
+	remote, err = r.Remotes.Lookup(DefaultRemoteName)
+	if err != nil {
+		return err
+	}
+	defer remote.Free()
+
+	return remote.Fetch(nil, &git.FetchOptions{RemoteCallbacks: cb}, "")
+}
+
 func (o *ClientOptions) Validate() bool {
 	if len(o.URL) > 0 && len(o.Path) > 0 {
 		return true
@@ -496,3 +744,131 @@ func makeCredentialsCallback(username, password string) git.CredentialsCallback
 		return cred, err
 	}
 }
+
+// Repack writes every object reachable from the references in a single pack and deletes the loose objects and the
+// previous packs, the same way git gc does.
+func (c *Client) Repack() error {
+	pb, err := c.Repository.NewPackbuilder()
+	if err != nil {
+		return err
+	}
+	defer pb.Free()
+
+	walk, err := c.Repository.Walk()
+	if err != nil {
+		return err
+	}
+	defer walk.Free()
+
+	// annotated tags are not part of the walk
+	iterator, err := c.Repository.NewReferenceIteratorGlob("refs/tags/*")
+	if err != nil {
+		return err
+	}
+	defer iterator.Free()
+
+	for ref, err := iterator.Next(); err == nil; ref, err = iterator.Next() {
+		if ref.Type() == git.ReferenceOid {
+			object, err := c.Repository.Lookup(ref.Target())
+			if err == nil {
+				if object.Type() == git.ObjectTag {
+					err = pb.Insert(ref.Target(), "")
+				}
+				object.Free()
+			}
+			if err != nil {
+				ref.Free()
+				return err
+			}
+		}
+		ref.Free()
+	}
+
+	err = walk.PushGlob("*")
+	if err != nil {
+		return err
+	}
+
+	err = walk.PushHead()
+	if err != nil && !git.IsErrorCode(err, git.ErrorCodeUnbornBranch) && !git.IsErrorCode(err, git.ErrorCodeNotFound) {
+		return err
+	}
+
+	err = pb.InsertWalk(walk)
+	if err != nil {
+		return err
+	}
+
+	if pb.ObjectCount() == 0 {
+		return nil
+	}
+
+	// write in a temporary directory to tell the new pack from the existing ones
+	objects := filepath.Join(c.Repository.Path(), "objects")
+	packs := filepath.Join(objects, "pack")
+	tmp, err := ioutil.TempDir(packs, "tmp-repack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = pb.WriteToFile(tmp, 0444)
+	if err != nil {
+		return err
+	}
+
+	written, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, f := range written {
+		err = os.Rename(filepath.Join(tmp, f.Name()), filepath.Join(packs, f.Name()))
+		if err != nil {
+			return err
+		}
+		keep[strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))] = true
+	}
+
+	// drop the previous packs unless they are explicitly kept
+	existing, err := ioutil.ReadDir(packs)
+	if err != nil {
+		return err
+	}
+	for _, f := range existing {
+		base := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if f.IsDir() || !strings.HasPrefix(base, "pack-") || keep[base] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(packs, base+".keep")); err == nil {
+			continue
+		}
+		err = os.Remove(filepath.Join(packs, f.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	// drop the loose objects, all the reachable ones are packed
+	loose, err := ioutil.ReadDir(objects)
+	if err != nil {
+		return err
+	}
+	for _, f := range loose {
+		if f.IsDir() && len(f.Name()) == 2 {
+			err = os.RemoveAll(filepath.Join(objects, f.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	odb, err := c.Repository.Odb()
+	if err != nil {
+		return err
+	}
+	defer odb.Free()
+
+	return odb.Refresh()
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"github.com/libgit2/git2go/v34"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,6 +50,7 @@ func TestCascadeMerge(t *testing.T) {
 	t.Run("AutoResolveNotWorking", CascadeAutoResolveNotWorking(bare))
 	t.Run("MergeToDevelop", MergeToDevelop(bare))
 	t.Run("MergeDevelopToDevelop", MergeDevelopToDevelop(bare))
+	t.Run("MergeInBareRepository", MergeInBareRepository(bare))
 }
 
 func CascadeNoConflict(bare *git.Repository) func(t *testing.T) {
@@ -82,18 +85,18 @@ func CascadeNoConflict(bare *git.Repository) func(t *testing.T) {
 
 		err = client.CascadeMerge("release/48", nil)
 
-		stat, err := os.Stat(filepath.Join(work.Workdir(), "patch-1"))
-		CheckFatal(err, t)
-		if !stat.Mode().IsRegular() {
-			t.Fail()
-		}
-
-		stat, err = os.Stat(filepath.Join(work.Workdir(), "patch-2"))
-		CheckFatal(err, t)
-		if !stat.Mode().IsRegular() {
-			t.Fail()
-		}
-
+		// merges are done in memory, check the result on the remote
+		err = WorkOnBareRepository(bare,
+			&FileExistsOnBranchTask{
+				BranchName: "develop",
+				Filename:   "patch-1",
+				t:          t,
+			},
+			&FileExistsOnBranchTask{
+				BranchName: "develop",
+				Filename:   "patch-2",
+				t:          t,
+			})
 		CheckFatal(err, t)
 	}
 }
@@ -289,6 +292,46 @@ func MergeDevelopToDevelop(bare *git.Repository) func(t *testing.T) {
 	}
 }
 
+func MergeInBareRepository(bare *git.Repository) func(t *testing.T) {
+	return func(t *testing.T) {
+		err := WorkOnBareRepository(bare,
+			&CreateDummyFileOnBranchTask{
+				BranchName: "release/48",
+				Filename:   "ghi",
+				t:          t,
+			})
+		CheckFatal(err, t)
+
+		path := filepath.Join(filepath.Dir(bare.Path()), "cascade-bare")
+		client, err := NewClient(&ClientOptions{
+			Path: path,
+			URL:  bare.Path(),
+			Bare: true,
+		})
+		CheckFatal(err, t)
+		defer os.RemoveAll(path)
+		defer client.Close()
+
+		if !client.Repository.IsBare() {
+			t.Fatal("repository must be bare")
+		}
+
+		// release/49 into develop still conflicts on foo
+		state := client.CascadeMerge("release/48", nil)
+		if state == nil || state.Target != "develop" {
+			t.Errorf("cascade must stop on develop, got %v", state)
+		}
+
+		err = WorkOnBareRepository(bare,
+			&FileExistsOnBranchTask{
+				BranchName: "release/49",
+				Filename:   "ghi",
+				t:          t,
+			})
+		CheckFatal(err, t)
+	}
+}
+
 func CheckFatal(err error, t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
@@ -369,6 +412,25 @@ func (t *ChangeFileOnBranchTask) Do(client *Client) {
 	CheckFatal(err, t.t)
 }
 
+type CommitAsTask struct {
+	BranchName string
+	Filename   string
+	Author     *Author
+	t          *testing.T
+}
+
+func (t *CommitAsTask) Do(client *Client) {
+	author := client.Author
+	client.Author = t.Author
+	defer func() { client.Author = author }()
+
+	err := client.Checkout(t.BranchName)
+	CheckFatal(err, t.t)
+	client.CommitDummyFile(t.Filename, t.t)
+	err = client.Push(t.BranchName)
+	CheckFatal(err, t.t)
+}
+
 type FileExistsOnBranchTask struct {
 	BranchName string
 	Filename   string
@@ -426,6 +488,294 @@ func WorkOnBareRepository(bare *git.Repository, tasks ...Task) error {
 	return nil
 }
 
+func TestNewClient_Refspecs(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "refspecs-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "feature/winter", Filename: "bar", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "baz", t: t},
+	)
+	CheckFatal(err, t)
+
+	options := &CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/"}
+	work := filepath.Join(filepath.Dir(bare.Path()), "refspecs")
+	client, err := NewClient(&ClientOptions{
+		Path:     work,
+		URL:      bare.Path(),
+		Bare:     true,
+		Refspecs: options.Refspecs(DefaultRemoteName),
+	})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	for _, branch := range []string{"release/1", "develop"} {
+		commit, err := client.LookupRemoteCommit(branch)
+		CheckFatal(err, t)
+		commit.Free()
+	}
+
+	for _, branch := range []string{"feature/winter", "master"} {
+		if _, err := client.LookupRemoteCommit(branch); err == nil {
+			t.Errorf("%s must not be fetched", branch)
+		}
+	}
+}
+
+// The remote of a clone restricted to refspecs cannot be reached. We expect an error and nothing left on disk.
+func TestNewClient_RefspecsUnreachable(t *testing.T) {
+	work := filepath.Join(os.TempDir(), "refspecs-unreachable-"+time.Nanosecond.String())
+	os.RemoveAll(work)
+	defer os.RemoveAll(work)
+
+	options := &CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/"}
+	_, err := NewClient(&ClientOptions{
+		Path:     work,
+		URL:      "http://127.0.0.1:1/morphean-sa/winterfell.git",
+		Bare:     true,
+		Refspecs: options.Refspecs(DefaultRemoteName),
+	})
+	if err == nil {
+		t.Fatal("expected an error for an unreachable remote")
+	}
+	if _, err := os.Stat(work); !os.IsNotExist(err) {
+		t.Errorf("%s must be removed", work)
+	}
+}
+
+func TestClient_Fetch(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "fetch-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare, &InitializeWithReadmeTask{t: t})
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "fetch")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	err = WorkOnBareRepository(bare,
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "feature/winter", Filename: "bar", t: t},
+	)
+	CheckFatal(err, t)
+
+	options := &CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/"}
+	err = client.Fetch(options)
+	CheckFatal(err, t)
+
+	commit, err := client.LookupRemoteCommit("release/1")
+	CheckFatal(err, t)
+	commit.Free()
+
+	if _, err := client.LookupRemoteCommit("feature/winter"); err == nil {
+		t.Error("feature/winter must not be fetched")
+	}
+
+	options.FullFetch = true
+	err = client.Fetch(options)
+	CheckFatal(err, t)
+
+	commit, err = client.LookupRemoteCommit("feature/winter")
+	CheckFatal(err, t)
+	commit.Free()
+}
+
+func TestMergeBranches_Message(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "message-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "bar", t: t},
+	)
+	CheckFatal(err, t)
+
+	tmpl, err := NewMergeMessageTemplate(`Merge #{{.PullRequest.Id}} into {{.Target}} {{join .JiraKeys ","}} [skip ci]`)
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "message")
+	client, err := NewClient(&ClientOptions{
+		Path:         work,
+		URL:          bare.Path(),
+		Bare:         true,
+		MergeMessage: tmpl,
+	})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	state := client.CascadeMerge("release/1", &CascadeOptions{
+		DevelopmentName: "develop",
+		ReleasePrefix:   "release/",
+		PullRequest: &PullRequest{
+			Id:     7,
+			Title:  "WF-12 close the gates",
+			Source: &PullRequestRef{Branch: &PullRequestBranch{Name: "feature/WF-13"}},
+		},
+	})
+	if state != nil {
+		t.Fatal(state)
+	}
+
+	commit, err := client.LookupRemoteCommit("develop")
+	CheckFatal(err, t)
+	defer commit.Free()
+
+	if want := "Merge #7 into develop WF-12,WF-13 [skip ci]"; commit.Message() != want {
+		t.Errorf("Message() = %q, want %q", commit.Message(), want)
+	}
+}
+
+type recordingSigner struct {
+	contents [][]byte
+}
+
+func (s *recordingSigner) Sign(content []byte) (string, error) {
+	s.contents = append(s.contents, content)
+	return "-----BEGIN SSH SIGNATURE-----\ndGVzdA==\n-----END SSH SIGNATURE-----\n", nil
+}
+
+func TestMergeBranches_Signed(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "signed-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "bar", t: t},
+	)
+	CheckFatal(err, t)
+
+	signer := &recordingSigner{}
+	work := filepath.Join(filepath.Dir(bare.Path()), "signed")
+	client, err := NewClient(&ClientOptions{
+		Path:   work,
+		URL:    bare.Path(),
+		Bare:   true,
+		Signer: signer,
+	})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	state := client.CascadeMerge("release/1", &CascadeOptions{
+		DevelopmentName: "develop",
+		ReleasePrefix:   "release/",
+	})
+	if state != nil {
+		t.Fatal(state)
+	}
+
+	commit, err := client.LookupRemoteCommit("develop")
+	CheckFatal(err, t)
+	defer commit.Free()
+
+	signature, content, err := commit.ExtractSignature()
+	CheckFatal(err, t)
+
+	if len(signer.contents) != 1 || string(signer.contents[0]) != content {
+		t.Errorf("signed content does not match the commit content")
+	}
+	if !strings.Contains(signature, "BEGIN SSH SIGNATURE") {
+		t.Errorf("unexpected signature %q", signature)
+	}
+}
+
+func TestClient_MergeSignatures(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "signatures-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	arya := &Author{Name: "Arya Stark", Email: "arya.stark@winterfell.net"}
+	bot := &Author{Name: "Cascade Bot", Email: "bot@winterfell.net"}
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CommitAsTask{BranchName: "release/1", Filename: "foo", Author: arya, t: t},
+	)
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "signatures")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	pullRequestHead, err := client.LookupRemoteCommit("release/1")
+	CheckFatal(err, t)
+	hash := pullRequestHead.Id().String()[:12]
+	pullRequestHead.Free()
+
+	// someone else commits after the pull request
+	err = WorkOnBareRepository(bare, &CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "bar", t: t})
+	CheckFatal(err, t)
+	err = client.Fetch(nil)
+	CheckFatal(err, t)
+
+	source, err := client.LookupRemoteCommit("release/1")
+	CheckFatal(err, t)
+	defer source.Free()
+
+	trigger := &PullRequest{Source: &PullRequestRef{Commit: &PullRequestCommit{Hash: hash}}}
+
+	tests := []struct {
+		name          string
+		bot           *Author
+		mode          AuthorMode
+		wantAuthor    string
+		wantCommitter string
+	}{
+		{name: "Default", bot: nil, mode: "", wantAuthor: "Jon Snow", wantCommitter: "Jon Snow"},
+		{name: "LastCommit", bot: bot, mode: AuthorLastCommit, wantAuthor: "Jon Snow", wantCommitter: "Cascade Bot"},
+		{name: "PullRequest", bot: bot, mode: AuthorPullRequest, wantAuthor: "Arya Stark", wantCommitter: "Cascade Bot"},
+		{name: "Bot", bot: bot, mode: AuthorBot, wantAuthor: "Cascade Bot", wantCommitter: "Cascade Bot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.Author = tt.bot
+			client.AuthorMode = tt.mode
+			author, committer := client.MergeSignatures(source, trigger)
+			if author.Name != tt.wantAuthor {
+				t.Errorf("author = %v, want %v", author.Name, tt.wantAuthor)
+			}
+			if committer.Name != tt.wantCommitter {
+				t.Errorf("committer = %v, want %v", committer.Name, tt.wantCommitter)
+			}
+		})
+	}
+}
+
 func TestClientOptions_Validate(t *testing.T) {
 	type fields struct {
 		Author *Author
@@ -468,3 +818,349 @@ func TestClientOptions_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeBranches_Strategy(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  *MergeStrategy
+		wantErr   bool
+		changelog string
+		main      string
+	}{
+		{name: "NoStrategy", wantErr: true},
+		{
+			name:     "UnionWithoutIgnoreWhitespace",
+			strategy: &MergeStrategy{Favor: []FavorRule{{Path: "CHANGELOG.md", Favor: FavorUnion}}},
+			wantErr:  true,
+		},
+		{
+			name:      "UnionIgnoreWhitespace",
+			strategy:  &MergeStrategy{IgnoreWhitespace: true, Favor: []FavorRule{{Path: "CHANGELOG.md", Favor: FavorUnion}}},
+			changelog: "a\nb\nc\n",
+			main:      "func run() {\n}\n",
+		},
+		{
+			name:      "Theirs",
+			strategy:  &MergeStrategy{Favor: []FavorRule{{Path: "*", Favor: FavorTheirs}}},
+			changelog: "a\nc\n",
+			main:      "func run() {\n}\n",
+		},
+		{
+			name:      "Ours",
+			strategy:  &MergeStrategy{Favor: []FavorRule{{Path: "*.md", Favor: FavorOurs}, {Path: "*", Favor: FavorTheirs}}},
+			changelog: "a\nb\n",
+			main:      "func run() {\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path = filepath.Join(os.TempDir(), "strategy-"+time.Nanosecond.String()+".git")
+			os.RemoveAll(path)
+
+			bare, err := git.InitRepository(path, true)
+			CheckFatal(err, t)
+			defer os.RemoveAll(path)
+			defer bare.Free()
+
+			// each clone starts from master so that both branches fork from the same commit
+			CheckFatal(WorkOnBareRepository(bare,
+				&InitializeWithReadmeTask{t: t},
+				&ChangeFileOnBranchTask{BranchName: "master", Filename: "CHANGELOG.md", Content: "a\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "master", Filename: "main.go", Content: "func main() {\n}\n", t: t},
+			), t)
+			CheckFatal(WorkOnBareRepository(bare,
+				&ChangeFileOnBranchTask{BranchName: "develop", Filename: "CHANGELOG.md", Content: "a\nb\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "develop", Filename: "main.go", Content: "func main()  {\n}\n", t: t},
+			), t)
+			CheckFatal(WorkOnBareRepository(bare,
+				&ChangeFileOnBranchTask{BranchName: "release/1", Filename: "CHANGELOG.md", Content: "a\nc\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "release/1", Filename: "main.go", Content: "func run() {\n}\n", t: t},
+			), t)
+
+			work := filepath.Join(filepath.Dir(bare.Path()), "strategy")
+			client, err := NewClient(&ClientOptions{
+				Path:     work,
+				URL:      bare.Path(),
+				Bare:     true,
+				Strategy: tt.strategy,
+			})
+			CheckFatal(err, t)
+			defer os.RemoveAll(work)
+			defer client.Close()
+
+			_, err = client.MergeBranches("release/1", "develop", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeBranches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// unresolved files are left to the conflict pull request
+				if conflict := (&CascadeMergeState{error: err}).Conflict(); conflict == nil {
+					t.Errorf("MergeBranches() error = %v, want a conflict", err)
+				}
+				return
+			}
+
+			for filename, want := range map[string]string{"CHANGELOG.md": tt.changelog, "main.go": tt.main} {
+				if got := readBranchFile(client.Repository, "develop", filename, t); got != want {
+					t.Errorf("%s = %q, want %q", filename, got, want)
+				}
+			}
+		})
+	}
+}
+
+func readBranchFile(repository *git.Repository, branchName string, filename string, t *testing.T) string {
+	obj, err := repository.RevparseSingle(DefaultRemoteReferencePrefix + branchName + ":" + filename)
+	CheckFatal(err, t)
+	defer obj.Free()
+
+	blob, err := obj.AsBlob()
+	CheckFatal(err, t)
+	defer blob.Free()
+
+	return string(blob.Contents())
+}
+
+func TestMergeBranches_ResolutionRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []ResolutionRule
+		wantErr   bool
+		wantPaths []string
+	}{
+		{name: "NoRules", wantErr: true, wantPaths: []string{"CHANGELOG.md", "VERSION", "yarn.lock"}},
+		{name: "PartialRules", rules: []ResolutionRule{{Path: "CHANGELOG.md", Resolution: ResolveUnion}}, wantErr: true, wantPaths: []string{"CHANGELOG.md", "VERSION", "yarn.lock"}},
+		{
+			name: "FailingRule",
+			rules: []ResolutionRule{
+				{Path: "CHANGELOG.md", Resolution: "keep_target_version"},
+				{Path: "VERSION", Resolution: "keep_target_version"},
+				{Path: "*.lock", Resolution: ResolveTakeSource},
+			},
+			wantErr:   true,
+			wantPaths: []string{"CHANGELOG.md", "VERSION", "yarn.lock"},
+		},
+		{
+			name: "AllRules",
+			rules: []ResolutionRule{
+				{Path: "CHANGELOG.md", Resolution: ResolveUnion},
+				{Path: "VERSION", Resolution: "keep_target_version"},
+				{Path: "*.lock", Resolution: ResolveTakeSource},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path = filepath.Join(os.TempDir(), "resolution-"+time.Nanosecond.String()+".git")
+			os.RemoveAll(path)
+
+			bare, err := git.InitRepository(path, true)
+			CheckFatal(err, t)
+			defer os.RemoveAll(path)
+			defer bare.Free()
+
+			CheckFatal(WorkOnBareRepository(bare,
+				&InitializeWithReadmeTask{t: t},
+				&ChangeFileOnBranchTask{BranchName: "master", Filename: "CHANGELOG.md", Content: "a\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "master", Filename: "VERSION", Content: "1.0.0\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "master", Filename: "yarn.lock", Content: "gates@1.0.0\n", t: t},
+			), t)
+			CheckFatal(WorkOnBareRepository(bare,
+				&ChangeFileOnBranchTask{BranchName: "develop", Filename: "CHANGELOG.md", Content: "a\nb\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "develop", Filename: "VERSION", Content: "1.1.0-SNAPSHOT\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "develop", Filename: "yarn.lock", Content: "gates@1.1.0\n", t: t},
+			), t)
+			CheckFatal(WorkOnBareRepository(bare,
+				&ChangeFileOnBranchTask{BranchName: "release/1", Filename: "CHANGELOG.md", Content: "a\nc\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "release/1", Filename: "VERSION", Content: "1.0.1\n", t: t},
+				&ChangeFileOnBranchTask{BranchName: "release/1", Filename: "yarn.lock", Content: "gates@1.0.1\n", t: t},
+			), t)
+
+			work := filepath.Join(filepath.Dir(bare.Path()), "resolution")
+			client, err := NewClient(&ClientOptions{
+				Path:            work,
+				URL:             bare.Path(),
+				Bare:            true,
+				ResolutionRules: tt.rules,
+			})
+			CheckFatal(err, t)
+			defer os.RemoveAll(work)
+			defer client.Close()
+
+			oid, err := client.MergeBranches("release/1", "develop", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeBranches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) || !reflect.DeepEqual(conflict.Paths, tt.wantPaths) {
+					t.Errorf("MergeBranches() error = %v, want a conflict in %v", err, tt.wantPaths)
+				}
+				return
+			}
+
+			for filename, want := range map[string]string{"CHANGELOG.md": "a\nb\nc\n", "VERSION": "1.1.0-SNAPSHOT\n", "yarn.lock": "gates@1.0.1\n"} {
+				if got := readBranchFile(client.Repository, "develop", filename, t); got != want {
+					t.Errorf("%s = %q, want %q", filename, got, want)
+				}
+			}
+
+			commit, err := client.Repository.LookupCommit(oid)
+			CheckFatal(err, t)
+			defer commit.Free()
+
+			want := "Automatic merge release/1 into develop\n\nResolved conflicts:\n\tCHANGELOG.md (union)\n\tVERSION (keep_target_version)\n\tyarn.lock (take_source)\n"
+			if commit.Message() != want {
+				t.Errorf("Message() = %q, want %q", commit.Message(), want)
+			}
+		})
+	}
+}
+
+func TestCascadeMerge_Until(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "until-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	CheckFatal(WorkOnBareRepository(bare, &InitializeWithReadmeTask{t: t}), t)
+	CheckFatal(WorkOnBareRepository(bare, &CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "foo", t: t}), t)
+	CheckFatal(WorkOnBareRepository(bare, &CreateDummyFileOnBranchTask{BranchName: "release/2", Filename: "bar", t: t}), t)
+	CheckFatal(WorkOnBareRepository(bare, &CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "baz", t: t}), t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "until")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	develop, err := client.LookupRemoteCommit("develop")
+	CheckFatal(err, t)
+	defer develop.Free()
+
+	hops := make([]*CascadeHop, 0)
+	state := client.CascadeMerge("release/1", &CascadeOptions{
+		DevelopmentName: "develop",
+		ReleasePrefix:   "release/",
+		Until:           "release/2",
+		OnHop: func(hop *CascadeHop) {
+			hops = append(hops, hop)
+		},
+	})
+	if state != nil {
+		t.Fatal(state)
+	}
+
+	if len(hops) != 1 || hops[0].Source != "release/1" || hops[0].Target != "release/2" || hops[0].Status != HopMerged || len(hops[0].Commit) != 40 {
+		t.Errorf("unexpected hops %+v", hops)
+	}
+
+	if got := readBranchFile(client.Repository, "release/2", "baz", t); len(got) == 0 {
+		t.Error("release/1 is not merged into release/2")
+	}
+
+	after, err := client.LookupRemoteCommit("develop")
+	CheckFatal(err, t)
+	defer after.Free()
+	if !after.Id().Equal(develop.Id()) {
+		t.Error("develop received a merge past release/2")
+	}
+}
+
+func TestClient_BuildCascade_Rules(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "rules-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "bar", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/2", Filename: "baz", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/3", Filename: "qux", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/4", Filename: "quux", t: t},
+	)
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "rules")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	tests := []struct {
+		name  string
+		start string
+		rules *CascadeRules
+		want  []string
+	}{
+		{name: "NoRules", start: "release/1", want: []string{"release/1", "release/2", "release/3", "release/4", "develop"}},
+		{name: "Exclude", start: "release/1", rules: &CascadeRules{Exclude: []string{"release/2"}, ExcludeRegex: []string{`^release/[4]$`}}, want: []string{"release/1", "release/3", "develop"}},
+		{name: "ExcludedStart", start: "release/2", rules: &CascadeRules{Exclude: []string{"release/2"}}, want: []string{}},
+		{name: "StopAt", start: "release/1", rules: &CascadeRules{StopAt: "release/3"}, want: []string{"release/1", "release/2", "release/3"}},
+		{name: "Active", start: "release/1", rules: &CascadeRules{InactiveDays: 1}, want: []string{"release/1", "release/2", "release/3", "release/4", "develop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cascade, err := client.BuildCascade(&CascadeOptions{
+				DevelopmentName: "develop",
+				ReleasePrefix:   "release/",
+				Rules:           tt.rules,
+			}, tt.start)
+			CheckFatal(err, t)
+
+			if !reflect.DeepEqual(cascade.Branches, tt.want) {
+				t.Errorf("Branches = %v, want %v", cascade.Branches, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_CreateIntermediateBranch(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "intermediate-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/2", Filename: "foo", t: t},
+	)
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "intermediate")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	name := IntermediateBranchName("release/2", "release/3")
+	if name != "cascade/release-2-into-release-3" {
+		t.Errorf("IntermediateBranchName() = %s", name)
+	}
+
+	// created twice, the second push replaces the first one
+	CheckFatal(client.CreateIntermediateBranch("release/2", name), t)
+	CheckFatal(client.CreateIntermediateBranch("release/2", name), t)
+
+	source, err := bare.References.Lookup("refs/heads/release/2")
+	CheckFatal(err, t)
+	defer source.Free()
+	intermediate, err := bare.References.Lookup("refs/heads/" + name)
+	CheckFatal(err, t)
+	defer intermediate.Free()
+
+	if !intermediate.Target().Equal(source.Target()) {
+		t.Errorf("intermediate branch points to %s, want %s", intermediate.Target(), source.Target())
+	}
+}
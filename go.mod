@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/ktrysmt/go-bitbucket v0.9.55
-	github.com/libgit2/git2go/v34 v34.0.0 // indirect
+	github.com/libgit2/git2go/v34 v34.0.0
+	golang.org/x/crypto v0.0.0-20201203163018-be400aefbc4c
 )
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 )
 
 type EventHandler struct {
 	channel chan<- PullRequestEvent
+	access  *AccessConfig
 }
 
 func (e EventHandler) Handle() http.Handler {
@@ -20,6 +22,12 @@ func (e EventHandler) Handle() http.Handler {
 			return
 		}
 
+		// the token is shared, only work on the repositories of the access lists. The payload is checked again by the
+		// worker against the repository returned by Bitbucket.
+		if !e.allowed(writer, event.Repository) {
+			return
+		}
+
 		// take only merged state
 		if event.PullRequest.State != Merged {
 			writer.WriteHeader(http.StatusUnprocessableEntity)
@@ -27,15 +35,68 @@ func (e EventHandler) Handle() http.Handler {
 		}
 
 		// notify the channel
-		select {
-		case e.channel <- event:
-			writer.WriteHeader(http.StatusCreated)
-		default:
-			writer.WriteHeader(http.StatusTooManyRequests)
+		e.enqueue(writer, event)
+	})
+}
+
+// TriggerRequest is the body of a cascade started by hand.
+type TriggerRequest struct {
+	// Repository is the full name of the repository (workspace/slug).
+	Repository string `json:"repository"`
+	// From is the branch the cascade starts from.
+	From string `json:"from"`
+}
+
+// Trigger enqueues a cascade from a branch of a repository without a webhook payload, when a merge was missed.
+func (e EventHandler) Trigger() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body TriggerRequest
+		err := json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		event, err := NewManualEvent(body.Repository, body.From)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
 		}
+
+		// only the full name is known here, the access lists are checked by the worker on the repository read from
+		// Bitbucket
+		e.enqueue(writer, event)
 	})
 }
 
+// Answers 403 when the repository is not allowed.
+func (e EventHandler) allowed(writer http.ResponseWriter, r *Repository) bool {
+	ok, reason := e.access.Allowed(r)
+	if !ok {
+		name := "unknown repository"
+		if r != nil {
+			name = r.FullName
+		}
+		log.Printf("refused event of %s: %s", name, reason)
+		writer.WriteHeader(http.StatusForbidden)
+	}
+	return ok
+}
+
+func (e EventHandler) enqueue(writer http.ResponseWriter, event PullRequestEvent) {
+	select {
+	case e.channel <- event:
+		writer.WriteHeader(http.StatusCreated)
+	default:
+		writer.WriteHeader(http.StatusTooManyRequests)
+	}
+}
+
 func (e EventHandler) CheckToken(token string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		if token != request.URL.Query().Get("token") {
@@ -46,6 +107,17 @@ func (e EventHandler) CheckToken(token string, next http.Handler) http.Handler {
 	})
 }
 
-func NewEventHandler(c chan PullRequestEvent) *EventHandler {
-	return &EventHandler{channel: c}
+// RequireToken is CheckToken refusing every request when no token is configured, for the endpoints which must be
+// authenticated.
+func (e EventHandler) RequireToken(token string, next http.Handler) http.Handler {
+	if len(token) == 0 {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusForbidden)
+		})
+	}
+	return e.CheckToken(token, next)
+}
+
+func NewEventHandler(c chan PullRequestEvent, access *AccessConfig) *EventHandler {
+	return &EventHandler{channel: c, access: access}
 }
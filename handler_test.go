@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +29,25 @@ func TestEventHandler_HandleValidPayload(t *testing.T) {
 	}
 }
 
+// The body is a pull request event of a repository which is not allowed. We expect a status 403 and nothing enqueued
+func TestEventHandler_HandleDenied(t *testing.T) {
+	c := make(chan PullRequestEvent, 1)
+	eh := EventHandler{channel: c, access: &AccessConfig{Allow: AccessList{Workspaces: []string{"winterfell"}}}}
+
+	rr, err := request("test/fixtures/hook-pull-request-fulfilled.json", eh.Handle())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+	if len(c) > 0 {
+		t.Error("nothing must be enqueued")
+	}
+}
+
 // The body is a pull request event but state is not set to MERGED. We expect a status 422
 func TestEventHandler_HandleUnsupportedState(t *testing.T) {
 	c := make(chan PullRequestEvent, 1)
@@ -76,6 +96,94 @@ func TestEventHandler_HandleBadRequest(t *testing.T) {
 	}
 }
 
+// The body names a repository and a branch. We expect a status 201 and a manual event enqueued
+func TestEventHandler_Trigger(t *testing.T) {
+	c := make(chan PullRequestEvent, 1)
+	eh := EventHandler{channel: c}
+
+	req := httptest.NewRequest("POST", "/cascade", strings.NewReader(`{"repository": "morphean-sa/winterfell", "from": "release/2"}`))
+	rr := httptest.NewRecorder()
+	eh.Trigger().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	event := <-c
+	if event.Repository.FullName != "morphean-sa/winterfell" || event.PullRequest.DestinationBranchName() != "release/2" {
+		t.Errorf("unexpected event %s from %s", event.Repository.FullName, event.PullRequest.DestinationBranchName())
+	}
+	if !event.PullRequest.Manual() {
+		t.Error("event must be manual")
+	}
+}
+
+// The repository of the trigger is allowed by its project, which is not known before it is read from Bitbucket. We
+// expect a status 201, the access lists are checked by the worker
+func TestEventHandler_TriggerProjectAllowed(t *testing.T) {
+	c := make(chan PullRequestEvent, 1)
+	eh := EventHandler{channel: c, access: &AccessConfig{Allow: AccessList{Projects: []string{"WF"}}}}
+
+	req := httptest.NewRequest("POST", "/cascade", strings.NewReader(`{"repository": "morphean-sa/winterfell", "from": "release/2"}`))
+	rr := httptest.NewRecorder()
+	eh.Trigger().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	if len(c) != 1 {
+		t.Error("the event must be enqueued")
+	}
+}
+
+// The trigger is malformed or not posted. We expect a status 400 or 405 and nothing enqueued
+func TestEventHandler_TriggerInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{name: "missing branch", method: "POST", body: `{"repository": "morphean-sa/winterfell"}`, want: http.StatusBadRequest},
+		{name: "missing workspace", method: "POST", body: `{"repository": "winterfell", "from": "release/2"}`, want: http.StatusBadRequest},
+		{name: "not json", method: "POST", body: `release/2`, want: http.StatusBadRequest},
+		{name: "get", method: "GET", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := make(chan PullRequestEvent, 1)
+			eh := EventHandler{channel: c}
+
+			rr := httptest.NewRecorder()
+			eh.Trigger().ServeHTTP(rr, httptest.NewRequest(tt.method, "/cascade", strings.NewReader(tt.body)))
+
+			if rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+			if len(c) > 0 {
+				t.Error("nothing must be enqueued")
+			}
+		})
+	}
+}
+
+// No token is configured. We expect the trigger to be refused with a status 403
+func TestEventHandler_RequireToken(t *testing.T) {
+	c := make(chan PullRequestEvent, 1)
+	eh := EventHandler{channel: c}
+
+	req := httptest.NewRequest("POST", "/cascade?token=", strings.NewReader(`{"repository": "morphean-sa/winterfell", "from": "release/2"}`))
+	rr := httptest.NewRecorder()
+	eh.RequireToken("", eh.Trigger()).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+	if len(c) > 0 {
+		t.Error("nothing must be enqueued")
+	}
+}
+
 func request(filename string, hf http.Handler) (*httptest.ResponseRecorder, error) {
 	file, _ := os.Open(filename)
 	req, err := http.NewRequest("POST", "/hook", file)
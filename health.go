@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// A HealthCheck returns a short human readable detail when the checked component is healthy or an error otherwise.
+type HealthCheck func() (string, error)
+
+type Health struct {
+	checks    map[string]HealthCheck
+	heartbeat int64
+	mutex     sync.RWMutex
+}
+
+type HealthReport struct {
+	Status string                        `json:"status"`
+	Checks map[string]*HealthCheckResult `json:"checks,omitempty"`
+}
+
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func NewHealth() *Health {
+	return &Health{
+		checks:    make(map[string]HealthCheck),
+		heartbeat: time.Now().UnixNano(),
+	}
+}
+
+// Register a named check evaluated by the readiness endpoint. Registering a name twice replaces the previous check.
+func (h *Health) Register(name string, check HealthCheck) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.checks[name] = check
+}
+
+// Beat records that the worker goroutine is alive.
+func (h *Health) Beat() {
+	atomic.StoreInt64(&h.heartbeat, time.Now().UnixNano())
+}
+
+func (h *Health) LastBeat() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&h.heartbeat))
+}
+
+// Live reports that the process is up and able to serve requests.
+func (h *Health) Live() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writeHealthReport(writer, &HealthReport{Status: HealthStatusOK})
+	})
+}
+
+// Ready evaluates every registered check and answers 503 if any of them fails.
+func (h *Health) Ready() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writeHealthReport(writer, h.Evaluate())
+	})
+}
+
+func (h *Health) Evaluate() *HealthReport {
+	h.mutex.RLock()
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mutex.RUnlock()
+
+	report := &HealthReport{
+		Status: HealthStatusOK,
+		Checks: make(map[string]*HealthCheckResult),
+	}
+
+	for name, check := range checks {
+		detail, err := check()
+		if err != nil {
+			report.Status = HealthStatusFail
+			report.Checks[name] = &HealthCheckResult{Status: HealthStatusFail, Detail: err.Error()}
+		} else {
+			report.Checks[name] = &HealthCheckResult{Status: HealthStatusOK, Detail: detail}
+		}
+	}
+
+	return report
+}
+
+// CheckHeartbeat fails when the worker did not beat for longer than the given timeout.
+func (h *Health) CheckHeartbeat(timeout time.Duration) HealthCheck {
+	return func() (string, error) {
+		elapsed := time.Since(h.LastBeat()).Round(time.Second)
+		if elapsed > timeout {
+			return "", fmt.Errorf("last heartbeat %s ago exceeds %s", elapsed, timeout)
+		}
+		return fmt.Sprintf("last heartbeat %s ago", elapsed), nil
+	}
+}
+
+// CheckQueue fails when the events channel is full and new webhooks would be rejected.
+func CheckQueue(events chan PullRequestEvent) HealthCheck {
+	return func() (string, error) {
+		if len(events) >= cap(events) {
+			return "", fmt.Errorf("queue saturated (%d/%d)", len(events), cap(events))
+		}
+		return fmt.Sprintf("%d/%d queued", len(events), cap(events)), nil
+	}
+}
+
+// CheckWritable fails when a file cannot be created in the given directory.
+func CheckWritable(dir string) HealthCheck {
+	return func() (string, error) {
+		f, err := ioutil.TempFile(dir, ".readyz-")
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		err = os.Remove(f.Name())
+		if err != nil {
+			return "", err
+		}
+		return dir + " is writable", nil
+	}
+}
+
+// CheckBitbucket fails when the Bitbucket API cannot be reached with the configured credentials.
+func CheckBitbucket(api *Bitbucket) HealthCheck {
+	return func() (string, error) {
+		err := api.Ping()
+		if err != nil {
+			return "", err
+		}
+		return "api reachable", nil
+	}
+}
+
+// CacheCheck reuses the result of the given check until the TTL elapses, for the checks calling rate limited APIs.
+func CacheCheck(check HealthCheck, ttl time.Duration) HealthCheck {
+	var (
+		mutex   sync.Mutex
+		expires time.Time
+		detail  string
+		err     error
+	)
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if time.Now().Before(expires) {
+			return detail, err
+		}
+		detail, err = check()
+		expires = time.Now().Add(ttl)
+		return detail, err
+	}
+}
+
+func writeHealthReport(writer http.ResponseWriter, report *HealthReport) {
+	writer.Header().Set("Content-Type", "application/json")
+	if report.Status != HealthStatusOK {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		writer.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(writer).Encode(report)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// Liveness does not depend on any check. We expect a status 200
+func TestHealth_Live(t *testing.T) {
+	h := NewHealth()
+	h.Register("failing", func() (string, error) {
+		return "", errors.New("boom")
+	})
+
+	rr := probe(h.Live())
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+}
+
+// All checks pass. We expect a status 200 with the detail of each check
+func TestHealth_ReadyOK(t *testing.T) {
+	h := NewHealth()
+	h.Register("worker", h.CheckHeartbeat(time.Minute))
+	h.Register("queue", CheckQueue(make(chan PullRequestEvent, 1)))
+	h.Register("workspace", CheckWritable(os.TempDir()))
+
+	rr := probe(h.Ready())
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var report HealthReport
+	err := json.NewDecoder(rr.Body).Decode(&report)
+	CheckFatal(err, t)
+
+	for _, name := range []string{"worker", "queue", "workspace"} {
+		if c := report.Checks[name]; c == nil || c.Status != HealthStatusOK {
+			t.Errorf("check %s must be reported as %s", name, HealthStatusOK)
+		}
+	}
+}
+
+// The queue is full and the worker is stale. We expect a status 503 with failing checks
+func TestHealth_ReadyFail(t *testing.T) {
+	events := make(chan PullRequestEvent, 1)
+	events <- PullRequestEvent{}
+
+	h := NewHealth()
+	h.heartbeat = time.Now().Add(-time.Hour).UnixNano()
+	h.Register("worker", h.CheckHeartbeat(time.Minute))
+	h.Register("queue", CheckQueue(events))
+	h.Register("workspace", CheckWritable(os.TempDir()))
+
+	rr := probe(h.Ready())
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	var report HealthReport
+	err := json.NewDecoder(rr.Body).Decode(&report)
+	CheckFatal(err, t)
+
+	if report.Status != HealthStatusFail {
+		t.Errorf("report status = %v, want %v", report.Status, HealthStatusFail)
+	}
+	for _, name := range []string{"worker", "queue"} {
+		if c := report.Checks[name]; c == nil || c.Status != HealthStatusFail {
+			t.Errorf("check %s must be reported as %s", name, HealthStatusFail)
+		}
+	}
+	if c := report.Checks["workspace"]; c == nil || c.Status != HealthStatusOK {
+		t.Errorf("check workspace must be reported as %s", HealthStatusOK)
+	}
+}
+
+func probe(h http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// The cached check is evaluated again only once the TTL elapsed
+func TestCacheCheck(t *testing.T) {
+	calls := 0
+	check := CacheCheck(func() (string, error) {
+		calls++
+		return "", errors.New("rate limited")
+	}, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := check(); err == nil {
+			t.Error("cached error must be returned")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("check called %d times, want 1", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	check()
+	if calls != 2 {
+		t.Errorf("check called %d times after the TTL, want 2", calls)
+	}
+}
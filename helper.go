@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func getEnv(key, fallback string) string {
@@ -10,3 +15,102 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			return b
+		}
+		log.Printf("invalid boolean %q for %s, using %t", value, key, fallback)
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			return d
+		}
+		log.Printf("invalid duration %q for %s, using %s", value, key, fallback)
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		n, err := strconv.Atoi(value)
+		if err == nil {
+			return n
+		}
+		log.Printf("invalid integer %q for %s, using %d", value, key, fallback)
+	}
+	return fallback
+}
+
+func getEnvSize(key string, fallback int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		n, err := parseSize(value)
+		if err == nil {
+			return n
+		}
+		log.Printf("invalid size %q for %s, using %d", value, key, fallback)
+	}
+	return fallback
+}
+
+// Parse a size in bytes with an optional K, M or G suffix (powers of 1024).
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) == 0 {
+		return 0, errors.New("empty size")
+	}
+
+	multiplier := int64(1)
+	switch value[len(value)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// Append the values missing from the slice.
+func appendUnique(slice []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, s := range slice {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
+
+// Remove every occurrence of the value from the slice.
+func removeString(slice []string, value string) []string {
+	result := slice[:0]
+	for _, s := range slice {
+		if s != value {
+			result = append(result, s)
+		}
+	}
+	return result
+}
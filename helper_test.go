@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{name: "bytes", value: "512", want: 512},
+		{name: "kilobytes", value: "2k", want: 2048},
+		{name: "megabytes", value: "3M", want: 3 << 20},
+		{name: "gigabytes", value: " 10G ", want: 10 << 30},
+		{name: "invalid", value: "ten", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
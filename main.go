@@ -1,87 +1,131 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// cascade started from the command line instead of a webhook
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		err := runCommand(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// initialize a buffered channel to process merges one at the time
 	events := make(chan PullRequestEvent, 100)
-	go worker(events)
 
-	// start the hook listener
-	handler := NewEventHandler(events)
-	addr := fmt.Sprintf(":%s", getEnv("PORT", "5000"))
-	http.Handle("/", handler.CheckToken(getEnv("TOKEN", ""), handler.Handle()))
-	err := http.ListenAndServe(addr, nil)
+	// retrieve auth from environment
+	username := getEnv("BITBUCKET_USERNAME", "")
+	password := getEnv("BITBUCKET_PASSWORD", "")
+
+	// per repository settings
+	config, err := LoadConfig(getEnv("CONFIG_FILE", ""))
 	if err != nil {
-		log.Fatalf("cannot start server on %s", addr)
+		log.Fatal(err)
 	}
 
-	close(events)
-}
-
-func worker(event <-chan PullRequestEvent) {
-	for e := range event {
+	// working copies of the repositories
+	workspace, err := newWorkspace()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// retrieve auth from environment
-		username := getEnv("BITBUCKET_USERNAME", "")
-		password := getEnv("BITBUCKET_PASSWORD", "")
+	// readiness checks
+	health := NewHealth()
+	health.Register("worker", health.CheckHeartbeat(getEnvDuration("HEARTBEAT_TIMEOUT", 10*time.Minute)))
+	health.Register("queue", CheckQueue(events))
+	health.Register("workspace", CheckWritable(workspace.Root))
+	health.Register("bitbucket", CacheCheck(CheckBitbucket(NewBitbucket(username, password, "", "")), getEnvDuration("BITBUCKET_CHECK_TTL", time.Minute)))
 
-		// get the clone url which is not provided in the webhook
-		api := NewBitbucket(username, password, e.Repository.Owner.UUID, e.Repository.Name)
-		url, err := api.GetCloneURL("https")
+	// enqueue the events left over by the previous shutdown
+	queueFile := getEnv("QUEUE_FILE", "")
+	if len(queueFile) > 0 {
+		pending, err := LoadEvents(queueFile)
 		if err != nil {
-			log.Printf("cannot read clone url of %s (owner=%s): %s", e.Repository.Name, e.Repository.Owner.UUID, err)
-			continue
+			log.Printf("cannot load pending events from %s: %s", queueFile, err)
 		}
+		for _, e := range pending {
+			select {
+			case events <- e:
+			default:
+				log.Printf("queue is full, dropping pending event of %s", e.Repository.Name)
+			}
+		}
+	}
+
+	w := NewWorker(events, health, workspace, config)
+	if mode := w.reconcileMode; mode != ReconcileReport && mode != ReconcileTrigger {
+		log.Fatalf("invalid reconcile mode %q, expected report or trigger", mode)
+	}
+	go w.Run()
 
-		c, err := NewClient(&ClientOptions{
-			Path: filepath.Join(os.TempDir(), e.Repository.Uuid),
-			URL:  url,
-			Credentials: &Credentials{
-				Username: username,
-				Password: password,
-			},
-		})
+	// start the hook listener
+	handler := NewEventHandler(events, config.Access)
+	addr := fmt.Sprintf(":%s", getEnv("PORT", "5000"))
+	http.Handle("/healthz", health.Live())
+	http.Handle("/readyz", health.Ready())
+	http.Handle("/cascade", handler.RequireToken(getEnv("TOKEN", ""), handler.Trigger()))
+	http.Handle("/status", handler.CheckToken(getEnv("TOKEN", ""), w.Drifts.Status()))
+	http.Handle("/metrics", handler.CheckToken(getEnv("TOKEN", ""), w.Drifts.Metrics()))
+	http.Handle("/", handler.CheckToken(getEnv("TOKEN", ""), handler.Handle()))
+	server := &http.Server{Addr: addr}
 
-		if err != nil {
-			log.Printf("failed to initialize git repository: %s", err)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("cannot start server on %s", addr)
 		}
+	}()
 
-		// query repository branching model to know which branches are candidate for cascading
-		opts, err := api.GetCascadeOptions(e.Repository.Owner.UUID, e.Repository.Name)
-		if err != nil {
-			log.Printf("cannot detect cascade options for %s, check branching model", e.Repository.Name)
-			continue
-		}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	s := <-signals
+	log.Printf("received %s, shutting down", s)
 
-		// check destination branch is candidate for auto merge
-		destination := e.PullRequest.Destination.Branch.Name
-		if strings.HasPrefix(destination, opts.DevelopmentName) && !strings.HasPrefix(destination, opts.ReleasePrefix) {
-			continue
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 5*time.Minute))
+	defer cancel()
 
-		// cascade merge the pull request
-		state := c.CascadeMerge(e.PullRequest.Destination.Branch.Name, opts)
-		if state != nil {
+	// stop accepting webhooks first so that nothing is enqueued while draining
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("cannot shutdown server gracefully: %s", err)
+	}
 
-			// create a new pull request when cascade fails
-			err := api.CreatePullRequest(
-				"Automatic merge failure",
-				"There was a merge conflict automatically merging this branch",
-				state.Source,
-				state.Target)
+	remaining, err := w.Shutdown(ctx)
+	if err != nil {
+		log.Printf("cascade still in progress after deadline: %s", err)
+	}
 
+	if len(remaining) > 0 {
+		if len(queueFile) > 0 {
+			err = SaveEvents(queueFile, remaining)
 			if err != nil {
-				log.Printf("could not create a pull request %s to %s on %s", state.Source, state.Target, e.Repository.Name)
+				log.Printf("cannot save %d pending events to %s: %s", len(remaining), queueFile, err)
+			} else {
+				log.Printf("saved %d pending events to %s", len(remaining), queueFile)
+			}
+		} else {
+			for _, e := range remaining {
+				log.Printf("rejecting pending event of %s (pull request #%d)", e.Repository.Name, e.PullRequest.Id)
 			}
 		}
-
 	}
 }
+
+func newWorkspace() (*Workspace, error) {
+	return NewWorkspace(
+		getEnv("WORKSPACE_DIR", filepath.Join(os.TempDir(), "bitbucket-cascade-merge")),
+		getEnvInt("WORKSPACE_MAX_COUNT", 0),
+		getEnvSize("WORKSPACE_MAX_SIZE", 0))
+}
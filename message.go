@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const DefaultMergeMessage = "Automatic merge {{.Source}} into {{.Target}}"
+
+var jiraKeyPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+
+// MergeMessageData is given to the merge message template.
+type MergeMessageData struct {
+	Source      string
+	Target      string
+	PullRequest *PullRequest
+	JiraKeys    []string
+	Commits     []*MergedCommit
+	// Resolutions of the conflicts, always recorded at the end of the message.
+	Resolutions []*ConflictResolution
+}
+
+// MergedCommit is a commit of the source branch brought to the target branch by the merge.
+type MergedCommit struct {
+	Id      string
+	ShortId string
+	Summary string
+	Author  string
+}
+
+// NewMergeMessageTemplate parses a merge message template, the default message is used when text is empty.
+func NewMergeMessageTemplate(text string) (*template.Template, error) {
+	if len(strings.TrimSpace(text)) == 0 {
+		text = DefaultMergeMessage
+	}
+	return template.New("merge_message").Funcs(template.FuncMap{
+		"join":  strings.Join,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trim":  strings.TrimSpace,
+	}).Option("missingkey=zero").Parse(text)
+}
+
+// Render the merge message, falling back to the default message when the template is nil. The resolved conflicts
+// are listed after the rendered message.
+func (d *MergeMessageData) Render(t *template.Template) (string, error) {
+	if t == nil {
+		t = template.Must(NewMergeMessageTemplate(""))
+	}
+	var b bytes.Buffer
+	err := t.Execute(&b, d)
+	if err != nil {
+		return "", err
+	}
+	if len(d.Resolutions) > 0 {
+		b.WriteString("\n\nResolved conflicts:\n")
+		for _, r := range d.Resolutions {
+			fmt.Fprintf(&b, "\t%s (%s)\n", r.Path, r.Resolution)
+		}
+	}
+	return b.String(), nil
+}
+
+// Extract the unique Jira issue keys found in the given texts, in order of appearance.
+func extractJiraKeys(texts ...string) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, key := range jiraKeyPattern.FindAllString(text, -1) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
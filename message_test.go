@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeMessageData_Render(t *testing.T) {
+	data := &MergeMessageData{
+		Source: "release/2",
+		Target: "release/3",
+		PullRequest: &PullRequest{
+			Id:    42,
+			Title: "WF-12 close the gates",
+		},
+		JiraKeys: []string{"WF-12", "WF-13"},
+		Commits: []*MergedCommit{
+			{ShortId: "420b26f53923", Summary: "close the gates"},
+			{ShortId: "b393f468241f", Summary: "lock the doors"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "Default", template: "", want: "Automatic merge release/2 into release/3"},
+		{
+			name:     "PullRequest",
+			template: "Merge #{{.PullRequest.Id}} {{.PullRequest.Title}} into {{.Target}} [skip ci]",
+			want:     "Merge #42 WF-12 close the gates into release/3 [skip ci]",
+		},
+		{
+			name:     "JiraKeys",
+			template: "{{join .JiraKeys \" \"}} merge {{.Source}}",
+			want:     "WF-12 WF-13 merge release/2",
+		},
+		{
+			name:     "Commits",
+			template: "Merge {{.Source}}\n{{range .Commits}}\n* {{.ShortId}} {{.Summary}}{{end}}",
+			want:     "Merge release/2\n\n* 420b26f53923 close the gates\n* b393f468241f lock the doors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := NewMergeMessageTemplate(tt.template)
+			CheckFatal(err, t)
+			got, err := data.Render(tmpl)
+			CheckFatal(err, t)
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMessageData_Render_Resolutions(t *testing.T) {
+	data := &MergeMessageData{
+		Source: "release/2",
+		Target: "release/3",
+		Resolutions: []*ConflictResolution{
+			{Path: "CHANGELOG.md", Resolution: ResolveUnion},
+			{Path: "package.json", Resolution: "keep_target_version"},
+		},
+	}
+
+	got, err := data.Render(nil)
+	CheckFatal(err, t)
+
+	want := "Automatic merge release/2 into release/3\n\nResolved conflicts:\n\tCHANGELOG.md (union)\n\tpackage.json (keep_target_version)\n"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func Test_extractJiraKeys(t *testing.T) {
+	got := extractJiraKeys("WF-12 close the gates", "Also fixes WF-13 and WF-12.", "feature/KL-7-gates")
+	want := []string{"WF-12", "WF-13", "KL-7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractJiraKeys() = %v, want %v", got, want)
+	}
+}
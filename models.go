@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"math"
+	"path"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type PullRequestEvent struct {
@@ -22,6 +26,154 @@ type PullRequest struct {
 	Author      *Author          `json:"author"`
 	Source      *PullRequestRef  `json:"source"`
 	Destination *PullRequestRef  `json:"destination"`
+	Links       map[string]Link  `json:"links"`
+	// Participants are the reviewers and the users who commented or approved the pull request.
+	Participants []*Participant `json:"participants"`
+}
+
+type Participant struct {
+	User     *Author `json:"user"`
+	Role     string  `json:"role"`
+	Approved bool    `json:"approved"`
+}
+
+// Reviewers returns the UUIDs of the author and of the approvers of the pull request, in that order and without
+// duplicate.
+func (p *PullRequest) Reviewers() []string {
+	uuids := make([]string, 0)
+	add := func(a *Author) {
+		if a == nil || len(a.UUID) == 0 {
+			return
+		}
+		for _, uuid := range uuids {
+			if uuid == a.UUID {
+				return
+			}
+		}
+		uuids = append(uuids, a.UUID)
+	}
+
+	add(p.Author)
+	for _, participant := range p.Participants {
+		if participant.Approved {
+			add(participant.User)
+		}
+	}
+	return uuids
+}
+
+// SourceBranchName returns the name of the branch merged by the pull request or an empty string.
+func (p *PullRequest) SourceBranchName() string {
+	if p.Source != nil && p.Source.Branch != nil {
+		return p.Source.Branch.Name
+	}
+	return ""
+}
+
+// DestinationBranchName returns the name of the branch receiving the pull request or an empty string.
+func (p *PullRequest) DestinationBranchName() string {
+	if p.Destination != nil && p.Destination.Branch != nil {
+		return p.Destination.Branch.Name
+	}
+	return ""
+}
+
+var (
+	noCascadePattern    = regexp.MustCompile(`(?i)\[no[- ]cascade\]`)
+	cascadeUntilPattern = regexp.MustCompile(`(?i)\[cascade[- ]until\s+([^\]\s]+)\s*\]`)
+)
+
+// SkipCascade tells whether the title or the description of the pull request holds the [no-cascade] marker.
+func (p *PullRequest) SkipCascade() bool {
+	return noCascadePattern.MatchString(p.Title) || noCascadePattern.MatchString(p.Description)
+}
+
+// CascadeUntil returns the branch given by a [cascade-until <branch>] marker in the title or the description of the
+// pull request, or an empty string.
+func (p *PullRequest) CascadeUntil() string {
+	for _, text := range []string{p.Title, p.Description} {
+		if m := cascadeUntilPattern.FindStringSubmatch(text); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+var (
+	cascadeOriginPattern  = regexp.MustCompile(`(?m)^Cascade-Origin: #(\d+)\s*$`)
+	cascadeResumesPattern = regexp.MustCompile(`(?m)^Cascade-Resumes: (\d+)\s*$`)
+)
+
+// CascadeOrigin links a conflict pull request opened by the service to the pull request which started the cascade.
+// It is written in the description of the conflict pull request so that its merge resumes the cascade.
+type CascadeOrigin struct {
+	// PullRequest is the id of the pull request which started the cascade, 0 for a cascade started by hand.
+	PullRequest int `json:"pull_request,omitempty"`
+	// Resumes counts the conflict pull requests merged since the cascade started, including this one.
+	Resumes int `json:"resumes"`
+}
+
+// Next returns the origin written in the conflict pull request opened by a cascade of the given pull request. A nil
+// origin stands for a cascade which was not resumed.
+func (o *CascadeOrigin) Next(pr *PullRequest) *CascadeOrigin {
+	if o == nil {
+		return &CascadeOrigin{PullRequest: pr.Id, Resumes: 1}
+	}
+	return &CascadeOrigin{PullRequest: o.PullRequest, Resumes: o.Resumes + 1}
+}
+
+// Trailer returns the lines of the description of a conflict pull request read back by PullRequest.CascadeOrigin.
+func (o *CascadeOrigin) Trailer() string {
+	var lines []string
+	if o.PullRequest > 0 {
+		lines = append(lines, fmt.Sprintf("Cascade-Origin: #%d", o.PullRequest))
+	}
+	lines = append(lines, fmt.Sprintf("Cascade-Resumes: %d", o.Resumes))
+	return strings.Join(lines, "\n")
+}
+
+// CascadeOrigin returns the origin written in the description of a conflict pull request, or nil.
+func (p *PullRequest) CascadeOrigin() *CascadeOrigin {
+	m := cascadeResumesPattern.FindStringSubmatch(p.Description)
+	if m == nil {
+		return nil
+	}
+
+	origin := &CascadeOrigin{}
+	origin.Resumes, _ = strconv.Atoi(m[1])
+	if m = cascadeOriginPattern.FindStringSubmatch(p.Description); m != nil {
+		origin.PullRequest, _ = strconv.Atoi(m[1])
+	}
+	return origin
+}
+
+// NewManualEvent returns the event of a cascade started by hand from a branch of a repository given by its full name
+// (workspace/slug). The pull request of the event has no id, its title tells the cascade is manual, its author is empty
+// and both its source and destination are the branch, so that merge message templates still render.
+func NewManualEvent(fullName, branch string) (PullRequestEvent, error) {
+	repository, err := NewRepository(fullName)
+	if err != nil {
+		return PullRequestEvent{}, err
+	}
+	if len(branch) == 0 {
+		return PullRequestEvent{}, errors.New("missing branch to cascade from")
+	}
+
+	return PullRequestEvent{
+		Repository: repository,
+		PullRequest: &PullRequest{
+			Title:       "Manual cascade from " + branch,
+			State:       Merged,
+			Author:      &Author{},
+			Source:      &PullRequestRef{Branch: &PullRequestBranch{Name: branch}},
+			Destination: &PullRequestRef{Branch: &PullRequestBranch{Name: branch}},
+		},
+	}, nil
+}
+
+// Manual tells whether the pull request stands for a cascade started by hand, which has no pull request on Bitbucket.
+func (p *PullRequest) Manual() bool {
+	return p.Id == 0
 }
 
 type PullRequestState string
@@ -41,7 +193,7 @@ type PullRequestBranch struct {
 }
 
 type PullRequestCommit struct {
-	Hash  string          `json:"name"`
+	Hash  string          `json:"hash"`
 	Links map[string]Link `json:"links"`
 }
 
@@ -53,20 +205,37 @@ type PullRequestRepository struct {
 }
 
 type Repository struct {
-	Uuid    string   `json:"uuid"`
-	Name    string   `json:"name"`
-	Links   Links    `json:"links"`
-	Project *Project `json:"project"`
-	Owner   *Owner   `json:"owner"`
+	Uuid     string   `json:"uuid"`
+	Name     string   `json:"name"`
+	FullName string   `json:"full_name"`
+	Links    Links    `json:"links"`
+	Project  *Project `json:"project"`
+	Owner    *Owner   `json:"owner"`
+}
+
+// NewRepository returns a repository given by its full name (workspace/slug), its UUID is unknown.
+func NewRepository(fullName string) (*Repository, error) {
+	parts := strings.Split(fullName, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("invalid repository %q, expected workspace/slug", fullName)
+	}
+	return &Repository{
+		Name:     parts[1],
+		FullName: fullName,
+		Owner:    &Owner{UUID: parts[0]},
+	}, nil
 }
 
 type Project struct {
+	Key   string          `json:"key"`
+	UUID  string          `json:"uuid"`
 	Name  string          `json:"name"`
 	Links map[string]Link `json:"links"`
 }
 
 type Links struct {
 	Self  *Link
+	Html  *Link   `json:"html,omitempty"`
 	Clone []*Link `json:"clone,omitempty"`
 }
 
@@ -76,10 +245,13 @@ type Link struct {
 }
 
 type Author struct {
-	Raw   string `json:"raw"`
-	User  *User  `json:"user,omitempty"`
-	Name  string
-	Email string
+	Raw         string `json:"raw"`
+	User        *User  `json:"user,omitempty"`
+	Name        string
+	Email       string
+	DisplayName string `json:"display_name,omitempty"`
+	UUID        string `json:"uuid,omitempty"`
+	AccountId   string `json:"account_id,omitempty"`
 }
 
 type Owner struct {
@@ -97,6 +269,35 @@ type CascadeMergeState struct {
 	error
 }
 
+// Conflict returns the conflict which stopped the cascade, nil when it failed for another reason.
+func (s *CascadeMergeState) Conflict() *ConflictError {
+	var conflict *ConflictError
+	if s != nil && errors.As(s.error, &conflict) {
+		return conflict
+	}
+	return nil
+}
+
+// AuthorMode tells whose identity is used as author of the merge commits.
+type AuthorMode string
+
+const (
+	// AuthorLastCommit uses the author of the last commit of the merged branch.
+	AuthorLastCommit AuthorMode = "last_commit"
+	// AuthorPullRequest uses the author of the head commit of the pull request which triggered the cascade.
+	AuthorPullRequest AuthorMode = "pull_request"
+	// AuthorBot uses the identity of the client.
+	AuthorBot AuthorMode = "bot"
+)
+
+func (m AuthorMode) Validate() error {
+	switch m {
+	case "", AuthorLastCommit, AuthorPullRequest, AuthorBot:
+		return nil
+	}
+	return fmt.Errorf("unknown author mode %q", m)
+}
+
 type Cascade struct {
 	Branches []string
 	Current  int
@@ -105,6 +306,133 @@ type Cascade struct {
 type CascadeOptions struct {
 	DevelopmentName string
 	ReleasePrefix   string
+	ProductionName  string
+	// FullFetch fetches every branch of the remote instead of the cascade branches only.
+	FullFetch bool
+	// PullRequest is the merged pull request which triggered the cascade, if any.
+	PullRequest *PullRequest
+	// Until is the last branch of the cascade, the cascade runs to the end when empty.
+	Until string
+	// Rules exclude branches from the cascade.
+	Rules *CascadeRules
+	// OnHop is called after each merge of the cascade, the failed one included.
+	OnHop func(hop *CascadeHop)
+}
+
+type HopStatus string
+
+const (
+	HopMerged   HopStatus = "merged"
+	HopUpToDate HopStatus = "up_to_date"
+	HopFailed   HopStatus = "failed"
+)
+
+// CascadeHop is the result of merging a branch of the cascade into the next one.
+type CascadeHop struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	Status HopStatus `json:"status"`
+	// Commit is the id of the pushed merge commit.
+	Commit string `json:"commit,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Eligible tells whether a pull request merged into the given branch starts a cascade, that is the branch is part of
+// the cascade and is not its last branch. The reason is returned when it does not.
+func (o *CascadeOptions) Eligible(destination string) (bool, string) {
+	switch {
+	case len(destination) == 0:
+		return false, "no destination branch"
+	case destination == o.DevelopmentName:
+		return false, fmt.Sprintf("%s is the last branch of the cascade", destination)
+	case !strings.HasPrefix(destination, o.ReleasePrefix):
+		return false, fmt.Sprintf("%s is not part of the cascade", destination)
+	case o.Rules.Excluded(destination):
+		return false, fmt.Sprintf("%s is excluded from the cascade", destination)
+	case destination == o.Until:
+		return false, fmt.Sprintf("%s is the branch to cascade until", destination)
+	}
+
+	if o.Rules != nil && len(o.Rules.StopAt) > 0 {
+		if destination == o.Rules.StopAt {
+			return false, fmt.Sprintf("%s is the stop branch of the cascade", destination)
+		}
+		if ByVersion([]string{o.Rules.StopAt, destination}).Less(0, 1) {
+			return false, fmt.Sprintf("%s follows the stop branch %s", destination, o.Rules.StopAt)
+		}
+	}
+
+	return true, ""
+}
+
+// CascadeRules keep frozen branches out of the cascade.
+type CascadeRules struct {
+	// Exclude lists globs of branches to leave out of the cascade.
+	Exclude []string `json:"exclude,omitempty"`
+	// ExcludeRegex lists regular expressions of branches to leave out of the cascade.
+	ExcludeRegex []string `json:"exclude_regex,omitempty"`
+	// InactiveDays leaves out the branches without commit for that many days, zero keeps every branch.
+	InactiveDays int `json:"inactive_days,omitempty"`
+	// StopAt is the last branch receiving merges, the branches following it in the cascade are left out.
+	StopAt string `json:"stop_at,omitempty"`
+}
+
+func (r *CascadeRules) Validate() error {
+	for _, pattern := range r.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q", pattern)
+		}
+	}
+	for _, pattern := range r.ExcludeRegex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid exclude_regex pattern %q: %s", pattern, err)
+		}
+	}
+	if r.InactiveDays < 0 {
+		return errors.New("inactive_days cannot be negative")
+	}
+	return nil
+}
+
+// Excluded tells whether the branch matches one of the exclude patterns.
+func (r *CascadeRules) Excluded(branchName string) bool {
+	if r == nil {
+		return false
+	}
+	for _, pattern := range r.Exclude {
+		if matched, _ := path.Match(pattern, branchName); matched {
+			return true
+		}
+	}
+	for _, pattern := range r.ExcludeRegex {
+		if matched, _ := regexp.MatchString(pattern, branchName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Inactive tells whether a branch whose last commit was made at the given time is considered inactive.
+func (r *CascadeRules) Inactive(lastCommit time.Time, now time.Time) bool {
+	if r == nil || r.InactiveDays == 0 {
+		return false
+	}
+	return lastCommit.Before(now.AddDate(0, 0, -r.InactiveDays))
+}
+
+// Refspecs returns the fetch refspecs of the branches taking part in the cascade, mapped to the remote tracking
+// branches of the given remote.
+func (o *CascadeOptions) Refspecs(remote string) []string {
+	refspecs := make([]string, 0, 3)
+	for _, name := range []string{o.DevelopmentName, o.ProductionName} {
+		if len(name) > 0 {
+			refspecs = append(refspecs, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", name, remote, name))
+		}
+	}
+	if len(o.ReleasePrefix) > 0 {
+		refspecs = append(refspecs, fmt.Sprintf("+refs/heads/%s*:refs/remotes/%s/%s*", o.ReleasePrefix, remote, o.ReleasePrefix))
+	}
+	return refspecs
 }
 
 // It returns the next branch in the cascade or an empty string if it reached the end.
@@ -128,8 +456,12 @@ func (c *Cascade) Append(branchName string) {
 	sort.Sort(ByVersion(c.Branches))
 }
 
-// Slice cascade branches to have only the target branch and its following branches.
+// Slice cascade branches to have only the target branch and its following branches. An empty start branch keeps
+// every branch.
 func (c *Cascade) Slice(startBranch string) {
+	if len(startBranch) == 0 {
+		return
+	}
 	for _, branch := range c.Branches {
 		if branch != startBranch {
 			c.Branches = c.Branches[1:]
@@ -139,6 +471,29 @@ func (c *Cascade) Slice(startBranch string) {
 	}
 }
 
+// Until drops the branches following the given branch. The cascade is left unmodified and false is returned when it
+// does not contain the branch.
+func (c *Cascade) Until(branchName string) bool {
+	for i, branch := range c.Branches {
+		if branch == branchName {
+			c.Branches = c.Branches[:i+1]
+			return true
+		}
+	}
+	return false
+}
+
+// StopAt drops the branches ordered after the given branch, which does not need to be part of the cascade.
+func (c *Cascade) StopAt(branchName string) {
+	branches := c.Branches[:0]
+	for _, branch := range c.Branches {
+		if branch == branchName || !ByVersion([]string{branchName, branch}).Less(0, 1) {
+			branches = append(branches, branch)
+		}
+	}
+	c.Branches = branches
+}
+
 // Extract an int representation of the version found in the given branch. Branch must be named accordingly to the
 // following format :
 //     <kind>/<version>
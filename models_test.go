@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"math"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRepository_URL(t *testing.T) {
@@ -145,6 +148,7 @@ func TestCascade_Slice(t *testing.T) {
 		{name: "Unbound", fields: fields{BranchNames: []string{"release/3", "release/2"}, TargetBranch: "develop"}, want: []string{}},
 		{name: "BoundLast", fields: fields{BranchNames: []string{"develop", "release/3"}, TargetBranch: "develop"}, want: []string{"develop"}},
 		{name: "BoundFirst", fields: fields{BranchNames: []string{"develop", "release/2", "release/3"}, TargetBranch: "release/2"}, want: []string{"release/2", "release/3", "develop"}},
+		{name: "Empty", fields: fields{BranchNames: []string{"develop", "release/2"}, TargetBranch: ""}, want: []string{"release/2", "develop"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -163,6 +167,252 @@ func TestCascade_Slice(t *testing.T) {
 	}
 }
 
+func TestCascade_Until(t *testing.T) {
+	tests := []struct {
+		name   string
+		until  string
+		want   []string
+		wantOk bool
+	}{
+		{name: "Middle", until: "release/3", want: []string{"release/2", "release/3"}, wantOk: true},
+		{name: "Last", until: "develop", want: []string{"release/2", "release/3", "develop"}, wantOk: true},
+		{name: "Unknown", until: "release/9", want: []string{"release/2", "release/3", "develop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cascade{Branches: []string{"release/2", "release/3", "develop"}}
+			if ok := c.Until(tt.until); ok != tt.wantOk {
+				t.Errorf("Until() = %v, want %v", ok, tt.wantOk)
+			}
+			if !reflect.DeepEqual(c.Branches, tt.want) {
+				t.Errorf("Branches = %v, want %v", c.Branches, tt.want)
+			}
+		})
+	}
+}
+
+func TestCascade_StopAt(t *testing.T) {
+	tests := []struct {
+		name string
+		stop string
+		want []string
+	}{
+		{name: "Member", stop: "release/3", want: []string{"release/2", "release/3"}},
+		{name: "Missing", stop: "release/4", want: []string{"release/2", "release/3"}},
+		{name: "Before", stop: "release/1", want: []string{}},
+		{name: "Development", stop: "develop", want: []string{"release/2", "release/3", "develop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cascade{Branches: []string{"release/2", "release/3", "develop"}}
+			c.StopAt(tt.stop)
+			if !reflect.DeepEqual(c.Branches, tt.want) {
+				t.Errorf("Branches = %v, want %v", c.Branches, tt.want)
+			}
+		})
+	}
+}
+
+func TestCascadeRules_Excluded(t *testing.T) {
+	rules := &CascadeRules{
+		Exclude:      []string{"release/legacy-*"},
+		ExcludeRegex: []string{`^release/1[0-9]?$`},
+	}
+	tests := []struct {
+		branch string
+		want   bool
+	}{
+		{"release/legacy-2019", true},
+		{"release/1", true},
+		{"release/12", true},
+		{"release/2", false},
+		{"release/123", false},
+		{"develop", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			if got := rules.Excluded(tt.branch); got != tt.want {
+				t.Errorf("Excluded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var none *CascadeRules
+	if none.Excluded("release/1") {
+		t.Error("nil rules must not exclude any branch")
+	}
+}
+
+func TestCascadeRules_Inactive(t *testing.T) {
+	now := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	rules := &CascadeRules{InactiveDays: 90}
+
+	if rules.Inactive(now.AddDate(0, 0, -30), now) {
+		t.Error("branch with a commit 30 days ago is active")
+	}
+	if !rules.Inactive(now.AddDate(0, 0, -91), now) {
+		t.Error("branch with a commit 91 days ago is inactive")
+	}
+	if (&CascadeRules{}).Inactive(now.AddDate(-5, 0, 0), now) {
+		t.Error("zero inactive days keeps every branch")
+	}
+}
+
+// The eligibility is checked against the destination of the fulfilled pull request fixture, moved to other branches.
+func TestCascadeOptions_Eligible(t *testing.T) {
+	data, err := ioutil.ReadFile("test/fixtures/hook-pull-request-fulfilled.json")
+	CheckFatal(err, t)
+
+	tests := []struct {
+		name        string
+		destination string
+		until       string
+		rules       *CascadeRules
+		want        bool
+	}{
+		{name: "Fixture", want: false},
+		{name: "Release", destination: "release/2", want: true},
+		{name: "Feature", destination: "feature/revert-readme", want: false},
+		{name: "DevelopmentPrefix", destination: "develop-legacy", want: false},
+		{name: "Master", destination: "master", want: false},
+		{name: "Excluded", destination: "release/1", rules: &CascadeRules{Exclude: []string{"release/1"}}, want: false},
+		{name: "Until", destination: "release/3", until: "release/3", want: false},
+		{name: "StopAt", destination: "release/3", rules: &CascadeRules{StopAt: "release/3"}, want: false},
+		{name: "AfterStopAt", destination: "release/4", rules: &CascadeRules{StopAt: "release/3"}, want: false},
+		{name: "BeforeStopAt", destination: "release/2", rules: &CascadeRules{StopAt: "release/3"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e PullRequestEvent
+			CheckFatal(json.Unmarshal(data, &e), t)
+			if len(tt.destination) > 0 {
+				e.PullRequest.Destination.Branch.Name = tt.destination
+			}
+
+			opts := &CascadeOptions{
+				DevelopmentName: "develop",
+				ReleasePrefix:   "release/",
+				Until:           tt.until,
+				Rules:           tt.rules,
+			}
+			got, reason := opts.Eligible(e.PullRequest.DestinationBranchName())
+			if got != tt.want {
+				t.Errorf("Eligible() = %v, want %v", got, tt.want)
+			}
+			if !got && len(reason) == 0 {
+				t.Error("a reason is expected when the branch is not eligible")
+			}
+		})
+	}
+}
+
+func TestPullRequest_Reviewers(t *testing.T) {
+	pr := &PullRequest{
+		Author: &Author{UUID: "{arya}"},
+		Participants: []*Participant{
+			{User: &Author{UUID: "{sansa}"}, Role: "REVIEWER", Approved: true},
+			{User: &Author{UUID: "{bran}"}, Role: "REVIEWER"},
+			{User: &Author{UUID: "{arya}"}, Role: "PARTICIPANT", Approved: true},
+			{User: &Author{UUID: "{jon}"}, Role: "PARTICIPANT", Approved: true},
+		},
+	}
+
+	want := []string{"{arya}", "{sansa}", "{jon}"}
+	if got := pr.Reviewers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Reviewers() = %v, want %v", got, want)
+	}
+}
+
+func TestPullRequest_CascadeOrigin(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        *CascadeOrigin
+	}{
+		{name: "None", description: "There was a merge conflict automatically merging this branch", want: nil},
+		{name: "First", description: "conflict\n\nCascade-Origin: #7\nCascade-Resumes: 1", want: &CascadeOrigin{PullRequest: 7, Resumes: 1}},
+		{name: "Manual", description: "conflict\n\nCascade-Resumes: 2", want: &CascadeOrigin{Resumes: 2}},
+		{name: "Inline", description: "see Cascade-Resumes: 2 below", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &PullRequest{Description: tt.description}
+			if got := pr.CascadeOrigin(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CascadeOrigin() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCascadeOrigin_Next(t *testing.T) {
+	var origin *CascadeOrigin
+
+	// the conflict pull request of a cascade links to the triggering pull request
+	next := origin.Next(&PullRequest{Id: 7})
+	conflict := &PullRequest{Id: 8, Description: "conflict\n\n" + next.Trailer()}
+	if got := conflict.CascadeOrigin(); !reflect.DeepEqual(got, &CascadeOrigin{PullRequest: 7, Resumes: 1}) {
+		t.Fatalf("CascadeOrigin() = %+v", got)
+	}
+
+	// the conflict pull request of a resumed cascade keeps the original pull request
+	next = conflict.CascadeOrigin().Next(conflict)
+	if !reflect.DeepEqual(next, &CascadeOrigin{PullRequest: 7, Resumes: 2}) {
+		t.Errorf("Next() = %+v", next)
+	}
+}
+
+func TestNewManualEvent(t *testing.T) {
+	e, err := NewManualEvent("morphean-sa/winterfell", "release/2")
+	CheckFatal(err, t)
+
+	if e.Repository.Name != "winterfell" || e.Repository.Owner.UUID != "morphean-sa" {
+		t.Errorf("repository = %s owned by %s", e.Repository.Name, e.Repository.Owner.UUID)
+	}
+	if e.PullRequest.DestinationBranchName() != "release/2" || e.PullRequest.State != Merged || !e.PullRequest.Manual() {
+		t.Errorf("unexpected pull request %+v", e.PullRequest)
+	}
+
+	// templates written for pull requests render the stub fields
+	message, err := NewMergeMessageTemplate("{{.PullRequest.Title}}{{.PullRequest.Author.DisplayName}} ({{.PullRequest.Source.Branch.Name}})")
+	CheckFatal(err, t)
+	rendered, err := (&MergeMessageData{PullRequest: e.PullRequest}).Render(message)
+	CheckFatal(err, t)
+	if rendered != "Manual cascade from release/2 (release/2)" {
+		t.Errorf("Render() = %q", rendered)
+	}
+
+	for _, invalid := range [][]string{{"winterfell", "release/2"}, {"morphean-sa/", "release/2"}, {"a/b/c", "develop"}, {"morphean-sa/winterfell", ""}} {
+		if _, err := NewManualEvent(invalid[0], invalid[1]); err == nil {
+			t.Errorf("NewManualEvent(%q, %q) must fail", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestPullRequest_Markers(t *testing.T) {
+	tests := []struct {
+		name      string
+		pr        PullRequest
+		wantSkip  bool
+		wantUntil string
+	}{
+		{name: "None", pr: PullRequest{Title: "WF-12 close the gates", Description: "cascade as usual"}},
+		{name: "NoCascadeTitle", pr: PullRequest{Title: "[no-cascade] WF-12 close the gates"}, wantSkip: true},
+		{name: "NoCascadeDescription", pr: PullRequest{Title: "WF-12", Description: "Handled differently on 3.\n\n[No Cascade]"}, wantSkip: true},
+		{name: "Until", pr: PullRequest{Title: "WF-12 close the gates [cascade-until release/3]"}, wantUntil: "release/3"},
+		{name: "UntilDescription", pr: PullRequest{Title: "WF-12", Description: "[Cascade-Until  release/3 ]"}, wantUntil: "release/3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pr.SkipCascade(); got != tt.wantSkip {
+				t.Errorf("SkipCascade() = %v, want %v", got, tt.wantSkip)
+			}
+			if got := tt.pr.CascadeUntil(); got != tt.wantUntil {
+				t.Errorf("CascadeUntil() = %q, want %q", got, tt.wantUntil)
+			}
+		})
+	}
+}
+
 func Test_extractVersion(t *testing.T) {
 	type args struct {
 		b string
@@ -198,3 +448,39 @@ func Test_extractVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestCascadeOptions_Refspecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		options CascadeOptions
+		want    []string
+	}{
+		{
+			name:    "DevelopAndRelease",
+			options: CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/"},
+			want: []string{
+				"+refs/heads/develop:refs/remotes/origin/develop",
+				"+refs/heads/release/*:refs/remotes/origin/release/*",
+			},
+		}, {
+			name:    "WithProduction",
+			options: CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/", ProductionName: "main"},
+			want: []string{
+				"+refs/heads/develop:refs/remotes/origin/develop",
+				"+refs/heads/main:refs/remotes/origin/main",
+				"+refs/heads/release/*:refs/remotes/origin/release/*",
+			},
+		}, {
+			name:    "DevelopOnly",
+			options: CascadeOptions{DevelopmentName: "develop"},
+			want:    []string{"+refs/heads/develop:refs/remotes/origin/develop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.options.Refspecs("origin"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Refspecs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type NotifierType string
+
+const (
+	NotifierSlack NotifierType = "slack"
+	NotifierTeams NotifierType = "teams"
+)
+
+// CascadeOutcome describes how a cascade ended, it is given to the notifiers.
+type CascadeOutcome struct {
+	Repository  *Repository
+	PullRequest *PullRequest
+	// State is nil when the cascade completed.
+	State *CascadeMergeState
+	// ConflictURL links to the pull request created to solve the conflict, if any.
+	ConflictURL string
+	// Hops are the merges of the cascade, in order.
+	Hops []*CascadeHop
+	// AuthorEmail is the address of the author of the pull request, only resolved when emails are configured.
+	AuthorEmail string
+	// Origin is set when the merge of a conflict pull request resumed the cascade of another pull request.
+	Origin *CascadeOrigin
+}
+
+func (o *CascadeOutcome) Failed() bool {
+	return o.State != nil
+}
+
+// A Notifier tells people about the outcome of a cascade.
+type Notifier interface {
+	Notify(o *CascadeOutcome) error
+}
+
+type NotifierConfig struct {
+	Type NotifierType `json:"type"`
+	// URL of the incoming webhook.
+	URL string `json:"url"`
+	// OnSuccess notifies the completed cascades too, failures are always notified.
+	OnSuccess bool `json:"on_success,omitempty"`
+	// Mentions maps Bitbucket account ids, UUIDs or display names to chat user ids.
+	Mentions map[string]string `json:"mentions,omitempty"`
+}
+
+func (nc *NotifierConfig) Validate() error {
+	switch nc.Type {
+	case NotifierSlack, NotifierTeams:
+	default:
+		return fmt.Errorf("unknown notifier type %q, expected slack or teams", nc.Type)
+	}
+	u, err := url.Parse(nc.URL)
+	if err != nil || len(u.Host) == 0 {
+		return fmt.Errorf("invalid notifier url %q", nc.URL)
+	}
+	return nil
+}
+
+// Notifier returns the notifier of the configured type.
+func (nc *NotifierConfig) Notifier() (Notifier, error) {
+	chat := ChatNotifier{
+		URL:       nc.URL,
+		OnSuccess: nc.OnSuccess,
+		Mentions:  nc.Mentions,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	switch nc.Type {
+	case NotifierSlack:
+		return &SlackNotifier{chat}, nil
+	case NotifierTeams:
+		return &TeamsNotifier{chat}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", nc.Type)
+}
+
+// ChatNotifier holds what Slack and Teams incoming webhooks have in common.
+type ChatNotifier struct {
+	URL       string
+	OnSuccess bool
+	Mentions  map[string]string
+	Client    *http.Client
+}
+
+// Mention returns the chat user id of the author, when mapped, or its display name.
+func (n *ChatNotifier) Mention(author *Author) (string, bool) {
+	if author == nil {
+		return "", false
+	}
+	for _, key := range []string{author.AccountId, author.UUID, author.DisplayName} {
+		if id, ok := n.Mentions[key]; ok && len(key) > 0 {
+			return id, true
+		}
+	}
+	return author.DisplayName, false
+}
+
+func (n *ChatNotifier) skip(o *CascadeOutcome) bool {
+	return !o.Failed() && !n.OnSuccess
+}
+
+func (n *ChatNotifier) post(payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+type SlackNotifier struct {
+	ChatNotifier
+}
+
+func (n *SlackNotifier) Notify(o *CascadeOutcome) error {
+	if n.skip(o) {
+		return nil
+	}
+
+	link := func(href, text string) string {
+		if len(href) == 0 {
+			return text
+		}
+		return fmt.Sprintf("<%s|%s>", href, text)
+	}
+
+	var author string
+	if o.Failed() {
+		if id, ok := n.Mention(o.PullRequest.Author); ok {
+			author = fmt.Sprintf("<@%s> ", id)
+		} else if len(id) > 0 {
+			author = fmt.Sprintf("@%s ", id)
+		}
+	}
+
+	return n.post(map[string]string{"text": author + outcomeText(o, link)})
+}
+
+type TeamsNotifier struct {
+	ChatNotifier
+}
+
+func (n *TeamsNotifier) Notify(o *CascadeOutcome) error {
+	if n.skip(o) {
+		return nil
+	}
+
+	link := func(href, text string) string {
+		if len(href) == 0 {
+			return text
+		}
+		return fmt.Sprintf("[%s](%s)", text, href)
+	}
+
+	// mentions of incoming webhooks are not rendered, the author is written in bold
+	var author string
+	if o.Failed() {
+		if id, _ := n.Mention(o.PullRequest.Author); len(id) > 0 {
+			author = fmt.Sprintf("**@%s** ", id)
+		}
+	}
+
+	text := author + outcomeText(o, link)
+	return n.post(map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  text,
+		"text":     text,
+	})
+}
+
+// The notification text, links are formatted by the given function.
+func outcomeText(o *CascadeOutcome, link func(href, text string) string) string {
+	repository := o.Repository.FullName
+	if len(repository) == 0 {
+		repository = o.Repository.Name
+	}
+
+	pr := "a merge"
+	if o.PullRequest != nil && o.PullRequest.Manual() {
+		pr = o.PullRequest.DestinationBranchName()
+	} else if o.PullRequest != nil {
+		pr = link(pullRequestLink(o.PullRequest), fmt.Sprintf("#%d %s", o.PullRequest.Id, o.PullRequest.Title))
+	}
+
+	if !o.Failed() {
+		return fmt.Sprintf("Cascade of %s on %s completed", pr, repository)
+	}
+
+	if len(o.State.Target) == 0 {
+		return fmt.Sprintf("Cascade of %s on %s failed: %s", pr, repository, errorText(o.State))
+	}
+
+	text := fmt.Sprintf("Cascade of %s on %s stopped, %s cannot be merged into %s", pr, repository, o.State.Source, o.State.Target)
+	if len(o.ConflictURL) > 0 {
+		text += ", please solve the " + link(o.ConflictURL, "conflict pull request")
+	}
+	return text
+}
+
+func pullRequestLink(p *PullRequest) string {
+	if p.Links == nil {
+		return ""
+	}
+	if html, ok := p.Links["html"]; ok {
+		return html.Href
+	}
+	return ""
+}
+
+func errorText(state *CascadeMergeState) string {
+	if state.error == nil {
+		return "unknown error"
+	}
+	return state.Error()
+}
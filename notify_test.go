@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A stub incoming webhook recording the posted messages.
+func stubWebhook(status int) (*httptest.Server, *[]map[string]string) {
+	received := make([]map[string]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var message map[string]string
+		if err := json.NewDecoder(request.Body).Decode(&message); err == nil {
+			received = append(received, message)
+		}
+		writer.WriteHeader(status)
+	}))
+	return server, &received
+}
+
+// The cascade of #7 stopped on a conflict between release/2 and release/3, shared by the notifier tests which adjust
+// the fields they need.
+func conflictOutcome() *CascadeOutcome {
+	return &CascadeOutcome{
+		Repository: &Repository{Uuid: "{winterfell}", Name: "winterfell", FullName: "morphean-sa/winterfell"},
+		PullRequest: &PullRequest{
+			Id:     7,
+			Title:  "WF-12 close the gates",
+			Author: &Author{DisplayName: "Arya Stark", AccountId: "5e3c1d7ae2a2820c950d6381"},
+			Links:  map[string]Link{"html": {Href: "https://bitbucket.org/morphean-sa/winterfell/pull-requests/7"}},
+		},
+		State:       &CascadeMergeState{Source: "release/2", Target: "release/3", error: &ConflictError{Paths: []string{"foo", "bar/baz.go"}}},
+		ConflictURL: "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8",
+	}
+}
+
+func TestSlackNotifier_Notify(t *testing.T) {
+	tests := []struct {
+		name     string
+		mentions map[string]string
+		want     string
+	}{
+		{
+			name:     "Mapped",
+			mentions: map[string]string{"5e3c1d7ae2a2820c950d6381": "U024BE7LH"},
+			want:     "<@U024BE7LH> Cascade of <https://bitbucket.org/morphean-sa/winterfell/pull-requests/7|#7 WF-12 close the gates> on morphean-sa/winterfell stopped, release/2 cannot be merged into release/3, please solve the <https://bitbucket.org/morphean-sa/winterfell/pull-requests/8|conflict pull request>",
+		},
+		{
+			name: "Unmapped",
+			want: "@Arya Stark Cascade of <https://bitbucket.org/morphean-sa/winterfell/pull-requests/7|#7 WF-12 close the gates> on morphean-sa/winterfell stopped, release/2 cannot be merged into release/3, please solve the <https://bitbucket.org/morphean-sa/winterfell/pull-requests/8|conflict pull request>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received := stubWebhook(http.StatusOK)
+			defer server.Close()
+
+			n, err := (&NotifierConfig{Type: NotifierSlack, URL: server.URL, Mentions: tt.mentions}).Notifier()
+			CheckFatal(err, t)
+			CheckFatal(n.Notify(conflictOutcome()), t)
+
+			if len(*received) != 1 {
+				t.Fatalf("received %d messages, want 1", len(*received))
+			}
+			if got := (*received)[0]["text"]; got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeamsNotifier_Notify(t *testing.T) {
+	server, received := stubWebhook(http.StatusOK)
+	defer server.Close()
+
+	n, err := (&NotifierConfig{Type: NotifierTeams, URL: server.URL}).Notifier()
+	CheckFatal(err, t)
+	CheckFatal(n.Notify(conflictOutcome()), t)
+
+	if len(*received) != 1 {
+		t.Fatalf("received %d messages, want 1", len(*received))
+	}
+	message := (*received)[0]
+	if message["@type"] != "MessageCard" {
+		t.Errorf("@type = %q, want MessageCard", message["@type"])
+	}
+	want := "**@Arya Stark** Cascade of [#7 WF-12 close the gates](https://bitbucket.org/morphean-sa/winterfell/pull-requests/7) on morphean-sa/winterfell stopped, release/2 cannot be merged into release/3, please solve the [conflict pull request](https://bitbucket.org/morphean-sa/winterfell/pull-requests/8)"
+	if message["text"] != want {
+		t.Errorf("text = %q, want %q", message["text"], want)
+	}
+}
+
+// Completed cascades are only notified when asked for, without mention.
+func TestChatNotifier_OnSuccess(t *testing.T) {
+	server, received := stubWebhook(http.StatusOK)
+	defer server.Close()
+
+	outcome := conflictOutcome()
+	outcome.State = nil
+	outcome.ConflictURL = ""
+
+	quiet, err := (&NotifierConfig{Type: NotifierSlack, URL: server.URL}).Notifier()
+	CheckFatal(err, t)
+	CheckFatal(quiet.Notify(outcome), t)
+	if len(*received) != 0 {
+		t.Fatalf("received %d messages, want 0", len(*received))
+	}
+
+	verbose, err := (&NotifierConfig{Type: NotifierSlack, URL: server.URL, OnSuccess: true}).Notifier()
+	CheckFatal(err, t)
+	CheckFatal(verbose.Notify(outcome), t)
+	if len(*received) != 1 || strings.HasPrefix((*received)[0]["text"], "@") {
+		t.Errorf("received %v, want a single message without mention", *received)
+	}
+}
+
+// The webhook rejects the message. We expect an error.
+func TestChatNotifier_Rejected(t *testing.T) {
+	server, _ := stubWebhook(http.StatusNotFound)
+	defer server.Close()
+
+	n, err := (&NotifierConfig{Type: NotifierTeams, URL: server.URL}).Notifier()
+	CheckFatal(err, t)
+	if err := n.Notify(conflictOutcome()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestNotifierConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  NotifierConfig
+		wantErr bool
+	}{
+		{name: "Slack", config: NotifierConfig{Type: NotifierSlack, URL: "https://hooks.slack.com/services/T0/B0/X"}},
+		{name: "Teams", config: NotifierConfig{Type: NotifierTeams, URL: "https://winterfell.webhook.office.com/webhookb2/x"}},
+		{name: "UnknownType", config: NotifierConfig{Type: "irc", URL: "https://irc.example.com"}, wantErr: true},
+		{name: "MissingURL", config: NotifierConfig{Type: NotifierSlack}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// SaveEvents writes the given events to a file, one JSON document per line, so they can be processed after a restart.
+func SaveEvents(path string, events []PullRequestEvent) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, e := range events {
+		err = encoder.Encode(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.Sync()
+}
+
+// LoadEvents reads the events saved by SaveEvents and removes the file, malformed lines are logged and skipped. A
+// missing file is not an error.
+func LoadEvents(path string) ([]PullRequestEvent, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := make([]PullRequestEvent, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		// a line cut by a crash while saving must not hold the other events
+		var e PullRequestEvent
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			log.Printf("skipped event at %s:%d: %s", path, line, err)
+			continue
+		}
+		if e.Repository == nil || e.PullRequest == nil {
+			log.Printf("skipped event at %s:%d: no repository or pull request", path, line)
+			continue
+		}
+		events = append(events, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, os.Remove(path)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveEvents_LoadEvents(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cascade-queue-")
+	CheckFatal(err, t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.jsonl")
+	events := []PullRequestEvent{
+		{Repository: &Repository{Name: "winterfell"}, PullRequest: &PullRequest{Id: 1, State: Merged}},
+		{Repository: &Repository{Name: "winterfell"}, PullRequest: &PullRequest{Id: 2, State: Merged}},
+	}
+
+	err = SaveEvents(path, events)
+	CheckFatal(err, t)
+
+	loaded, err := LoadEvents(path)
+	CheckFatal(err, t)
+
+	if len(loaded) != 2 || loaded[0].PullRequest.Id != 1 || loaded[1].PullRequest.Id != 2 {
+		t.Errorf("LoadEvents() = %v, want %v", loaded, events)
+	}
+	if loaded[0].Repository.Name != "winterfell" {
+		t.Errorf("repository = %v, want winterfell", loaded[0].Repository.Name)
+	}
+
+	// the file is consumed
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("queue file must be removed once loaded")
+	}
+
+	loaded, err = LoadEvents(path)
+	CheckFatal(err, t)
+	if len(loaded) != 0 {
+		t.Errorf("LoadEvents() = %v, want no event", loaded)
+	}
+}
+
+// The queue file holds a line cut by a crash between two events. We expect the other events loaded and the file removed.
+func TestLoadEvents_Malformed(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cascade-queue-")
+	CheckFatal(err, t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.jsonl")
+	err = SaveEvents(path, []PullRequestEvent{{Repository: &Repository{Name: "winterfell"}, PullRequest: &PullRequest{Id: 1, State: Merged}}})
+	CheckFatal(err, t)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	CheckFatal(err, t)
+	_, err = f.WriteString("{\"repository\": {\"name\": \"winter\n{}\n")
+	CheckFatal(err, t)
+	CheckFatal(f.Close(), t)
+
+	err = SaveEvents(path, []PullRequestEvent{{Repository: &Repository{Name: "winterfell"}, PullRequest: &PullRequest{Id: 2, State: Merged}}})
+	CheckFatal(err, t)
+
+	loaded, err := LoadEvents(path)
+	CheckFatal(err, t)
+
+	if len(loaded) != 2 || loaded[0].PullRequest.Id != 1 || loaded[1].PullRequest.Id != 2 {
+		t.Errorf("LoadEvents() = %v, want #1 and #2", loaded)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("queue file must be removed once loaded")
+	}
+}
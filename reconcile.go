@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReconcileReport only records the drifts, exposed by the status and metrics endpoints.
+	ReconcileReport = "report"
+	// ReconcileTrigger also cascades the first drifted branch of each repository.
+	ReconcileTrigger = "trigger"
+)
+
+// Drift compares a branch of the cascade with the following one.
+type Drift struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Ahead counts the commits of the source branch missing from the target branch.
+	Ahead int `json:"ahead"`
+	// Head is the last commit of the source branch.
+	Head string `json:"head"`
+}
+
+// RepositoryScan is the result of the last reconciliation scan of a repository.
+type RepositoryScan struct {
+	Repository string    `json:"repository"`
+	Time       time.Time `json:"time"`
+	Drifts     []*Drift  `json:"drifts"`
+	Error      string    `json:"error,omitempty"`
+	// Triggered tells whether a cascade was started from the first drifted branch.
+	Triggered bool `json:"triggered,omitempty"`
+	// Conflict tells the last triggered cascade stopped on a conflict, it is not triggered again until the drifts
+	// change.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// Drifted returns the first pair of branches which are not merged, or nil.
+func (s *RepositoryScan) Drifted() *Drift {
+	for _, d := range s.Drifts {
+		if d.Ahead > 0 {
+			return d
+		}
+	}
+	return nil
+}
+
+// DriftReport holds the last scan of each repository, safe for concurrent use.
+type DriftReport struct {
+	scans map[string]*RepositoryScan
+	mutex sync.RWMutex
+}
+
+func NewDriftReport() *DriftReport {
+	return &DriftReport{scans: make(map[string]*RepositoryScan)}
+}
+
+// Record replaces the last scan of the repository.
+func (r *DriftReport) Record(scan *RepositoryScan) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.scans[scan.Repository] = scan
+}
+
+// Scan returns the last scan of the repository, or nil.
+func (r *DriftReport) Scan(repository string) *RepositoryScan {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.scans[repository]
+}
+
+// Scans returns the last scan of each repository ordered by repository.
+func (r *DriftReport) Scans() []*RepositoryScan {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	scans := make([]*RepositoryScan, 0, len(r.scans))
+	for _, scan := range r.scans {
+		scans = append(scans, scan)
+	}
+	sort.Slice(scans, func(i, j int) bool {
+		return scans[i].Repository < scans[j].Repository
+	})
+	return scans
+}
+
+// Status answers the last scans as JSON.
+func (r *DriftReport) Status() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(map[string]interface{}{"repositories": r.Scans()})
+	})
+}
+
+// Metrics answers the last scans in the Prometheus text format.
+func (r *DriftReport) Metrics() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteMetrics(writer)
+	})
+}
+
+func (r *DriftReport) WriteMetrics(w io.Writer) {
+	scans := r.Scans()
+
+	fmt.Fprintln(w, "# HELP cascade_drift_commits Commits of a branch missing from the following branch of the cascade.")
+	fmt.Fprintln(w, "# TYPE cascade_drift_commits gauge")
+	for _, scan := range scans {
+		for _, d := range scan.Drifts {
+			fmt.Fprintf(w, "cascade_drift_commits{repository=%q,source=%q,target=%q} %d\n", scan.Repository, d.Source, d.Target, d.Ahead)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP cascade_scan_timestamp_seconds Time of the last reconciliation scan.")
+	fmt.Fprintln(w, "# TYPE cascade_scan_timestamp_seconds gauge")
+	for _, scan := range scans {
+		fmt.Fprintf(w, "cascade_scan_timestamp_seconds{repository=%q} %d\n", scan.Repository, scan.Time.Unix())
+	}
+
+	fmt.Fprintln(w, "# HELP cascade_scan_failed Whether the last reconciliation scan failed.")
+	fmt.Fprintln(w, "# TYPE cascade_scan_failed gauge")
+	for _, scan := range scans {
+		failed := 0
+		if len(scan.Error) > 0 {
+			failed = 1
+		}
+		fmt.Fprintf(w, "cascade_scan_failed{repository=%q} %d\n", scan.Repository, failed)
+	}
+}
+
+// Drift fetches the repository and compares each branch of the cascade with the following one.
+func (c *Client) Drift(options *CascadeOptions) ([]*Drift, error) {
+	err := c.Fetch(options)
+	if err != nil {
+		return nil, err
+	}
+
+	cascade, err := c.BuildCascade(options, "")
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := make([]*Drift, 0)
+	for i := 0; i+1 < len(cascade.Branches); i++ {
+		source, target := cascade.Branches[i], cascade.Branches[i+1]
+
+		sourceCommit, err := c.LookupRemoteCommit(source)
+		if err != nil {
+			return nil, err
+		}
+		targetCommit, err := c.LookupRemoteCommit(target)
+		if err != nil {
+			sourceCommit.Free()
+			return nil, err
+		}
+
+		head := sourceCommit.Id().String()
+		ahead, _, err := c.Repository.AheadBehind(sourceCommit.Id(), targetCommit.Id())
+		sourceCommit.Free()
+		targetCommit.Free()
+		if err != nil {
+			return nil, err
+		}
+
+		drifts = append(drifts, &Drift{Source: source, Target: target, Ahead: ahead, Head: head})
+	}
+	return drifts, nil
+}
+
+// Reconcile scans every repository of the configuration given by its full name for drifted branches. In trigger mode,
+// the drifted repositories are cascaded from the first drifted branch, unless the previous cascade stopped on a
+// conflict and the drifts did not change since. The scan is interrupted by a shutdown.
+func (w *Worker) Reconcile() {
+	for key := range w.Config.Repositories {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		if !strings.Contains(key, "/") {
+			log.Printf("cannot reconcile %s, repositories are scanned by full name", key)
+			continue
+		}
+
+		scan := w.scan(key)
+		if len(scan.Error) > 0 {
+			log.Printf("cannot reconcile %s: %s", key, scan.Error)
+		}
+
+		if drift := scan.Drifted(); drift != nil {
+			log.Printf("%s is %d commits ahead of %s on %s", drift.Source, drift.Ahead, drift.Target, key)
+			if w.reconcileMode == ReconcileTrigger {
+				w.trigger(scan, drift)
+			}
+		}
+
+		w.Drifts.Record(scan)
+		w.Health.Beat()
+	}
+}
+
+// Cascade a drifted repository, a conflict is only reported again by the following scans until it is solved.
+func (w *Worker) trigger(scan *RepositoryScan, drift *Drift) {
+	previous := w.Drifts.Scan(scan.Repository)
+	if previous != nil && previous.Conflict && reflect.DeepEqual(previous.Drifts, scan.Drifts) {
+		scan.Conflict = true
+		log.Printf("cascade of %s stopped on a conflict, waiting for the drifts to change", scan.Repository)
+		return
+	}
+
+	e, err := NewManualEvent(scan.Repository, drift.Source)
+	if err != nil {
+		log.Printf("cannot cascade %s: %s", scan.Repository, err)
+		return
+	}
+
+	scan.Triggered = true
+	outcome, err := w.Cascade(e)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	scan.Conflict = outcome != nil && outcome.State.Conflict() != nil
+}
+
+func (w *Worker) scan(fullName string) *RepositoryScan {
+	scan := &RepositoryScan{Repository: fullName, Time: time.Now(), Drifts: make([]*Drift, 0)}
+
+	username := getEnv("BITBUCKET_USERNAME", "")
+	password := getEnv("BITBUCKET_PASSWORD", "")
+
+	r, err := NewRepository(fullName)
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+
+	api := NewBitbucket(username, password, r.Owner.UUID, r.Name)
+	repository, err := api.GetRepository()
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+	if ok, reason := w.Config.Access.Allowed(repository); !ok {
+		scan.Error = fmt.Sprintf("refused: %s", reason)
+		return scan
+	}
+	uuid := repository.Uuid
+	url, err := api.GetCloneURL("https")
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+	opts, err := api.GetCascadeOptions(r.Owner.UUID, r.Name)
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+	opts.FullFetch = getEnvBool("FULL_FETCH", false)
+	r.Uuid = uuid
+	opts.Rules = w.Config.Repository(r).Cascade
+
+	var refspecs []string
+	if !opts.FullFetch {
+		refspecs = opts.Refspecs(DefaultRemoteName)
+	}
+
+	// make room for the working copy before cloning
+	evicted, err := w.Workspace.Evict(uuid)
+	for _, path := range evicted {
+		log.Printf("evicted working copy %s", path)
+	}
+	if err != nil {
+		log.Printf("cannot evict working copies: %s", err)
+	}
+
+	c, err := NewClient(&ClientOptions{
+		Path:     w.Workspace.Path(uuid),
+		URL:      url,
+		Bare:     true,
+		Refspecs: refspecs,
+		Credentials: &Credentials{
+			Username: username,
+			Password: password,
+		},
+	})
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+	defer c.Close()
+	defer w.Workspace.Touch(uuid)
+
+	drifts, err := c.Drift(opts)
+	if err != nil {
+		scan.Error = err.Error()
+		return scan
+	}
+	scan.Drifts = drifts
+	return scan
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"github.com/libgit2/git2go/v34"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClient_Drift(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "drift-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/48", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/49", Filename: "bar", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "baz", t: t},
+	)
+	CheckFatal(err, t)
+
+	work := filepath.Join(filepath.Dir(bare.Path()), "drift")
+	client, err := NewClient(&ClientOptions{Path: work, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(work)
+	defer client.Close()
+
+	options := &CascadeOptions{DevelopmentName: "develop", ReleasePrefix: "release/"}
+	drifts, err := client.Drift(options)
+	CheckFatal(err, t)
+	clearHeads(drifts, t)
+
+	want := []*Drift{
+		{Source: "release/48", Target: "release/49"},
+		{Source: "release/49", Target: "develop"},
+	}
+	if !reflect.DeepEqual(drifts, want) {
+		t.Errorf("Drift() = %+v, want %+v", drifts, want)
+	}
+
+	// a hotfix pushed without cascading
+	err = WorkOnBareRepository(bare,
+		&CreateDummyFileOnBranchTask{BranchName: "release/48", Filename: "hotfix-1", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/48", Filename: "hotfix-2", t: t},
+	)
+	CheckFatal(err, t)
+
+	drifts, err = client.Drift(options)
+	CheckFatal(err, t)
+	clearHeads(drifts, t)
+
+	want[0].Ahead = 2
+	if !reflect.DeepEqual(drifts, want) {
+		t.Errorf("Drift() = %+v, want %+v", drifts, want)
+	}
+}
+
+func clearHeads(drifts []*Drift, t *testing.T) {
+	for _, d := range drifts {
+		if len(d.Head) != 40 {
+			t.Errorf("head of %s = %q", d.Source, d.Head)
+		}
+		d.Head = ""
+	}
+}
+
+// The previous cascade stopped on a conflict and the drifts did not change. We expect no cascade.
+func TestWorker_TriggerAfterConflict(t *testing.T) {
+	w := NewWorker(nil, NewHealth(), nil, nil)
+	drifts := []*Drift{{Source: "release/2", Target: "release/3", Ahead: 1, Head: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"}}
+	w.Drifts.Record(&RepositoryScan{Repository: "morphean-sa/winterfell", Drifts: drifts, Triggered: true, Conflict: true})
+
+	scan := &RepositoryScan{Repository: "morphean-sa/winterfell", Drifts: []*Drift{{Source: "release/2", Target: "release/3", Ahead: 1, Head: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"}}}
+	w.trigger(scan, scan.Drifted())
+
+	if scan.Triggered || !scan.Conflict {
+		t.Errorf("scan triggered = %v, conflict = %v, want held on the conflict", scan.Triggered, scan.Conflict)
+	}
+}
+
+// A shutdown is requested. We expect no repository to be scanned.
+func TestWorker_ReconcileStopped(t *testing.T) {
+	config := &Config{Repositories: map[string]*RepositoryConfig{"morphean-sa/winterfell": {}}}
+	w := NewWorker(nil, NewHealth(), nil, config)
+	close(w.stop)
+
+	w.Reconcile()
+
+	if scans := w.Drifts.Scans(); len(scans) > 0 {
+		t.Errorf("Scans() = %+v, want none", scans)
+	}
+}
+
+func TestDriftReport(t *testing.T) {
+	report := NewDriftReport()
+	report.Record(&RepositoryScan{
+		Repository: "morphean-sa/winterfell",
+		Time:       time.Unix(1615809600, 0),
+		Drifts: []*Drift{
+			{Source: "release/2", Target: "release/3"},
+			{Source: "release/3", Target: "develop", Ahead: 4},
+		},
+	})
+	report.Record(&RepositoryScan{Repository: "morphean-sa/dragonstone", Time: time.Unix(1615809600, 0), Error: "authentication required"})
+
+	scans := report.Scans()
+	if len(scans) != 2 || scans[0].Repository != "morphean-sa/dragonstone" {
+		t.Fatalf("Scans() = %+v", scans)
+	}
+	if drift := scans[1].Drifted(); drift == nil || drift.Source != "release/3" {
+		t.Errorf("Drifted() = %+v, want release/3", drift)
+	}
+	if drift := scans[0].Drifted(); drift != nil {
+		t.Errorf("Drifted() = %+v, want nil", drift)
+	}
+
+	var b bytes.Buffer
+	report.WriteMetrics(&b)
+
+	for _, line := range []string{
+		`cascade_drift_commits{repository="morphean-sa/winterfell",source="release/2",target="release/3"} 0`,
+		`cascade_drift_commits{repository="morphean-sa/winterfell",source="release/3",target="develop"} 4`,
+		`cascade_scan_timestamp_seconds{repository="morphean-sa/winterfell"} 1615809600`,
+		`cascade_scan_failed{repository="morphean-sa/dragonstone"} 1`,
+		`cascade_scan_failed{repository="morphean-sa/winterfell"} 0`,
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(line+"\n")) {
+			t.Errorf("metrics do not contain %s:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libgit2/git2go/v34"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RepairRepository brings back a working copy left in an inconsistent state by a crash : stale lock files, refs that
+// do not point to an existing object and unfinished merges are cleaned up. It returns a description of each repair
+// applied, or an error when the repository cannot be repaired and must be cloned again.
+func RepairRepository(r *git.Repository) ([]string, error) {
+	repairs := make([]string, 0)
+
+	locks, err := removeLockFiles(r.Path())
+	if err != nil {
+		return repairs, err
+	}
+	for _, l := range locks {
+		repairs = append(repairs, "removed stale lock "+l)
+	}
+
+	refs, err := removeBrokenRefs(r)
+	if err != nil {
+		return repairs, err
+	}
+	for _, ref := range refs {
+		repairs = append(repairs, "removed broken reference "+ref)
+	}
+
+	// a repository without valid HEAD is not worth repairing
+	head, err := r.Head()
+	if err != nil && !git.IsErrorCode(err, git.ErrorCodeUnbornBranch) {
+		return repairs, fmt.Errorf("cannot resolve HEAD: %s", err)
+	}
+	if head != nil {
+		defer head.Free()
+	}
+
+	// a bare repository has neither index nor working directory to repair
+	if r.IsBare() {
+		return repairs, nil
+	}
+
+	index, err := r.Index()
+	if err != nil {
+		err = os.Remove(filepath.Join(r.Path(), "index"))
+		if err != nil {
+			return repairs, err
+		}
+		repairs = append(repairs, "removed unreadable index")
+	} else {
+		index.Free()
+	}
+
+	if state := r.State(); state != git.RepositoryStateNone {
+		err = r.StateCleanup()
+		if err != nil {
+			return repairs, err
+		}
+		repairs = append(repairs, fmt.Sprintf("aborted operation in progress (state=%d)", state))
+	}
+
+	// bring the working directory and the index back to HEAD
+	if head != nil && len(repairs) > 0 {
+		commit, err := r.LookupCommit(head.Target())
+		if err != nil {
+			return repairs, err
+		}
+		defer commit.Free()
+
+		err = r.ResetToCommit(commit, git.ResetHard, &git.CheckoutOpts{Strategy: git.CheckoutForce})
+		if err != nil {
+			return repairs, err
+		}
+		repairs = append(repairs, "reset to "+head.Target().String())
+	}
+
+	return repairs, nil
+}
+
+// Remove the lock files found in the git directory, objects excepted. It returns the removed files relative to the
+// git directory.
+func removeLockFiles(gitDir string) ([]string, error) {
+	removed := make([]string, 0)
+	err := filepath.Walk(gitDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == "objects" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".lock") {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(gitDir, path)
+			removed = append(removed, rel)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Remove the loose references which are not readable or point to a missing object. It returns the removed reference
+// names.
+func removeBrokenRefs(r *git.Repository) ([]string, error) {
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	defer odb.Free()
+
+	removed := make([]string, 0)
+	err = filepath.Walk(filepath.Join(r.Path(), "refs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		value := strings.TrimSpace(string(content))
+		if strings.HasPrefix(value, "ref: ") {
+			return nil
+		}
+
+		oid, err := git.NewOid(value)
+		if err == nil && odb.Exists(oid) {
+			return nil
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(r.Path(), path)
+		removed = append(removed, filepath.ToSlash(rel))
+		return nil
+	})
+	return removed, err
+}
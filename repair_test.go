@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/libgit2/git2go/v34"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewClient_RepairInterruptedMerge(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "repair-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare, &InitializeWithReadmeTask{t: t})
+	CheckFatal(err, t)
+
+	workPath := filepath.Join(filepath.Dir(bare.Path()), "repair")
+	work, err := git.Clone(bare.Path(), workPath, &git.CloneOptions{})
+	CheckFatal(err, t)
+	defer os.RemoveAll(workPath)
+
+	head, err := work.Head()
+	CheckFatal(err, t)
+	headId := head.Target().String()
+	head.Free()
+	gitDir := work.Path()
+	work.Free()
+
+	// simulate a crash in the middle of a merge
+	CheckFatal(ioutil.WriteFile(filepath.Join(gitDir, "MERGE_HEAD"), []byte(headId+"\n"), 0644), t)
+	CheckFatal(ioutil.WriteFile(filepath.Join(gitDir, "index.lock"), []byte{}, 0644), t)
+	CheckFatal(ioutil.WriteFile(filepath.Join(gitDir, "refs", "heads", "broken"), []byte("4b825d"), 0644), t)
+	CheckFatal(ioutil.WriteFile(filepath.Join(workPath, "README.md"), []byte("<<<<<<< HEAD\n"), 0644), t)
+
+	client, err := NewClient(&ClientOptions{
+		Path: workPath,
+		URL:  bare.Path(),
+	})
+	CheckFatal(err, t)
+	defer client.Close()
+
+	if state := client.Repository.State(); state != git.RepositoryStateNone {
+		t.Errorf("State() = %v, want %v", state, git.RepositoryStateNone)
+	}
+
+	if _, err := os.Stat(filepath.Join(gitDir, "index.lock")); !os.IsNotExist(err) {
+		t.Error("index.lock must be removed")
+	}
+
+	if _, err := os.Stat(filepath.Join(gitDir, "refs", "heads", "broken")); !os.IsNotExist(err) {
+		t.Error("broken reference must be removed")
+	}
+
+	readme, err := client.ReadFile("README.md")
+	CheckFatal(err, t)
+	if !reflect.DeepEqual(readme, []byte("# Cascade Merge\n")) {
+		t.Errorf("README.md = %q, want it reset to HEAD", readme)
+	}
+}
+
+// The repository cannot be opened anymore. We expect it to be cloned again.
+func TestNewClient_RecloneBrokenRepository(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "reclone-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare, &InitializeWithReadmeTask{t: t})
+	CheckFatal(err, t)
+
+	workPath := filepath.Join(filepath.Dir(bare.Path()), "reclone")
+	work, err := git.Clone(bare.Path(), workPath, &git.CloneOptions{})
+	CheckFatal(err, t)
+	defer os.RemoveAll(workPath)
+	gitDir := work.Path()
+	work.Free()
+
+	CheckFatal(ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("garbage"), 0644), t)
+
+	client, err := NewClient(&ClientOptions{
+		Path: workPath,
+		URL:  bare.Path(),
+	})
+	CheckFatal(err, t)
+	defer client.Close()
+
+	_, err = client.Repository.Head()
+	CheckFatal(err, t)
+}
+
+func TestNewClient_RecloneBrokenBareRepository(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "reclone-bare-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare, &InitializeWithReadmeTask{t: t})
+	CheckFatal(err, t)
+
+	workPath := filepath.Join(filepath.Dir(bare.Path()), "reclone-bare")
+	client, err := NewClient(&ClientOptions{Path: workPath, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer os.RemoveAll(workPath)
+	client.Close()
+
+	// a bare clone has no .git directory, its HEAD is at the root
+	CheckFatal(ioutil.WriteFile(filepath.Join(workPath, "HEAD"), []byte("garbage"), 0644), t)
+
+	client, err = NewClient(&ClientOptions{Path: workPath, URL: bare.Path(), Bare: true})
+	CheckFatal(err, t)
+	defer client.Close()
+
+	_, err = client.Repository.Head()
+	CheckFatal(err, t)
+}
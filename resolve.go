@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/libgit2/git2go/v34"
+	"log"
+	"regexp"
+	"strings"
+)
+
+type Resolution string
+
+const (
+	// ResolveUnion keeps the lines of both branches.
+	ResolveUnion Resolution = "union"
+	// ResolveTakeTarget keeps the file of the branch receiving the merge.
+	ResolveTakeTarget Resolution = "take_target"
+	// ResolveTakeSource keeps the file of the merged branch.
+	ResolveTakeSource Resolution = "take_source"
+)
+
+// A Resolver computes the content of a conflicting file from the contents of the common ancestor (nil when the file
+// was added on both branches), the branch receiving the merge and the merged branch.
+type Resolver func(ancestor, target, source []byte) ([]byte, error)
+
+// Resolvers can be referenced by name from a resolution rule.
+var Resolvers = map[string]Resolver{
+	"keep_target_version": keepTargetVersion,
+}
+
+// ResolutionRule resolves the conflicts of the files matching a glob, see FavorRule for the matching. Resolution is
+// union, take_target, take_source or the name of a resolver.
+type ResolutionRule struct {
+	Path       string     `json:"path"`
+	Resolution Resolution `json:"resolution"`
+}
+
+func (r *ResolutionRule) Match(p string) bool {
+	return matchPath(r.Path, p)
+}
+
+func (r *ResolutionRule) Validate() error {
+	if !validPath(r.Path) {
+		return fmt.Errorf("invalid path %q", r.Path)
+	}
+	switch r.Resolution {
+	case ResolveUnion, ResolveTakeTarget, ResolveTakeSource:
+		return nil
+	}
+	if _, ok := Resolvers[string(r.Resolution)]; !ok {
+		return fmt.Errorf("unknown resolution %q", r.Resolution)
+	}
+	return nil
+}
+
+// ConflictError is returned when a merge leaves files in conflict.
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("merge resulted in conflicts in %s, please solve the conflicts before merging", strings.Join(e.Paths, ", "))
+}
+
+// ConflictResolution records how a conflicting file was resolved.
+type ConflictResolution struct {
+	Path       string
+	Resolution Resolution
+}
+
+// ApplyResolutionRules resolves the given conflicting paths with the first matching rule. Nothing is resolved unless
+// every path matches a rule, the returned ConflictError lists every path left in conflict.
+func (c *Client) ApplyResolutionRules(index *git.Index, paths []string, rules []ResolutionRule) ([]*ConflictResolution, error) {
+	matched := make([]*ResolutionRule, len(paths))
+	var unmatched []string
+	for i, p := range paths {
+		for j := range rules {
+			if rules[j].Match(p) {
+				matched[i] = &rules[j]
+				break
+			}
+		}
+		if matched[i] == nil {
+			unmatched = append(unmatched, p)
+		}
+	}
+	if len(unmatched) > 0 {
+		log.Printf("no resolution rule for %s", strings.Join(unmatched, ", "))
+		return nil, &ConflictError{Paths: paths}
+	}
+
+	resolutions := make([]*ConflictResolution, 0, len(paths))
+	for i, p := range paths {
+		conflict, err := index.Conflict(p)
+		if err != nil {
+			return nil, err
+		}
+
+		// the rule cannot resolve this file, the conflict is left to be solved by hand
+		entry, err := c.resolveConflict(conflict, matched[i].Resolution)
+		if err != nil {
+			log.Printf("cannot resolve %s with %s: %s", p, matched[i].Resolution, err)
+			return nil, &ConflictError{Paths: paths[i:]}
+		}
+
+		err = index.RemoveConflict(p)
+		if err != nil {
+			return nil, err
+		}
+		// a nil entry keeps the file deleted
+		if entry != nil {
+			err = index.Add(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resolutions = append(resolutions, &ConflictResolution{Path: p, Resolution: matched[i].Resolution})
+	}
+
+	return resolutions, nil
+}
+
+func (c *Client) resolveConflict(conflict git.IndexConflict, resolution Resolution) (*git.IndexEntry, error) {
+	switch resolution {
+	case ResolveTakeTarget:
+		return conflict.Our, nil
+	case ResolveTakeSource:
+		return conflict.Their, nil
+	}
+
+	if conflict.Our == nil || conflict.Their == nil {
+		return nil, errors.New("file deleted on one side")
+	}
+
+	var ancestor []byte
+	if conflict.Ancestor != nil {
+		input, err := c.mergeFileInput(conflict.Ancestor)
+		if err != nil {
+			return nil, err
+		}
+		ancestor = input.Contents
+	}
+	target, err := c.mergeFileInput(conflict.Our)
+	if err != nil {
+		return nil, err
+	}
+	source, err := c.mergeFileInput(conflict.Their)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolver Resolver
+	if resolution == ResolveUnion {
+		resolver = unionResolver
+	} else {
+		resolver = Resolvers[string(resolution)]
+	}
+	if resolver == nil {
+		return nil, fmt.Errorf("unknown resolution %q", resolution)
+	}
+
+	contents, err := resolver(ancestor, target.Contents, source.Contents)
+	if err != nil {
+		return nil, err
+	}
+
+	oid, err := c.Repository.CreateBlobFromBuffer(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := *conflict.Our
+	entry.Id = oid
+	entry.Size = uint32(len(contents))
+	return &entry, nil
+}
+
+func unionResolver(ancestor, target, source []byte) ([]byte, error) {
+	return mergeContents(ancestor, target, source, git.MergeFileFavorUnion)
+}
+
+var versionPattern = regexp.MustCompile(`(?i)^.*\bversion\b["']?\s*[:=].*$`)
+
+// Keeps the version of the target branch: version lines (version = ..., "version": ...) of the ancestor and the source
+// are replaced by the ones of the target before merging. A version line is matched by its context, the closest line
+// before it which is not a version line (like the name of a dependency), so that versions do not shift when a
+// dependency is added or removed. A file holding a single line, like VERSION, is taken from the target.
+func keepTargetVersion(ancestor, target, source []byte) ([]byte, error) {
+	if len(bytes.Split(bytes.TrimSpace(target), []byte("\n"))) == 1 {
+		return target, nil
+	}
+
+	versions := versionLines(target)
+	if len(versions) == 0 {
+		return nil, errors.New("no version found")
+	}
+
+	targetLines := bytes.Split(target, []byte("\n"))
+	replace := func(content []byte) ([]byte, error) {
+		lines := bytes.Split(content, []byte("\n"))
+		others := versionLines(content)
+		for context, indexes := range others {
+			// the same context holds another number of versions, they cannot be told apart
+			if want, ok := versions[context]; ok && len(want) != len(indexes) {
+				return nil, fmt.Errorf("ambiguous versions after %q", context)
+			}
+		}
+		for context, indexes := range others {
+			for i, index := range indexes {
+				if replacement, ok := versions[context]; ok {
+					lines[index] = targetLines[replacement[i]]
+				}
+			}
+		}
+		return bytes.Join(lines, []byte("\n")), nil
+	}
+
+	ancestor, err := replace(ancestor)
+	if err != nil {
+		return nil, err
+	}
+	source, err = replace(source)
+	if err != nil {
+		return nil, err
+	}
+	return mergeContents(ancestor, target, source, git.MergeFileFavorNormal)
+}
+
+// Returns the indexes of the version lines by context, the closest previous line which is not a version line.
+func versionLines(content []byte) map[string][]int {
+	versions := make(map[string][]int)
+	context := ""
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if versionPattern.Match(line) {
+			versions[context] = append(versions[context], i)
+		} else if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			context = string(trimmed)
+		}
+	}
+	return versions
+}
+
+func mergeContents(ancestor, target, source []byte, favor git.MergeFileFavor) ([]byte, error) {
+	result, err := git.MergeFile(
+		git.MergeFileInput{Contents: ancestor},
+		git.MergeFileInput{Contents: target},
+		git.MergeFileInput{Contents: source},
+		&git.MergeFileOptions{Favor: favor},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Free()
+
+	if !result.Automergeable {
+		return nil, errors.New("still in conflict")
+	}
+	return result.Contents, nil
+}
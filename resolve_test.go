@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolutionRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ResolutionRule
+		wantErr bool
+	}{
+		{name: "Union", rule: ResolutionRule{Path: "CHANGELOG.md", Resolution: ResolveUnion}},
+		{name: "TakeTarget", rule: ResolutionRule{Path: "*.lock", Resolution: ResolveTakeTarget}},
+		{name: "Resolver", rule: ResolutionRule{Path: "package.json", Resolution: "keep_target_version"}},
+		{name: "UnknownResolver", rule: ResolutionRule{Path: "package.json", Resolution: "keep_both"}, wantErr: true},
+		{name: "EmptyPath", rule: ResolutionRule{Resolution: ResolveUnion}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_keepTargetVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		ancestor string
+		target   string
+		source   string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "VersionFile",
+			ancestor: "1.0.0\n",
+			target:   "1.1.0-SNAPSHOT\n",
+			source:   "1.0.1\n",
+			want:     "1.1.0-SNAPSHOT\n",
+		},
+		{
+			name:     "PackageJson",
+			ancestor: "{\n  \"name\": \"winterfell\",\n  \"version\": \"1.0.0\",\n  \"license\": \"MIT\",\n\n  \"private\": true\n}\n",
+			target:   "{\n  \"name\": \"winterfell\",\n  \"version\": \"1.1.0\",\n  \"license\": \"MIT\",\n\n  \"private\": true\n}\n",
+			source:   "{\n  \"name\": \"winterfell\",\n  \"version\": \"1.0.1\",\n  \"license\": \"MIT\",\n\n  \"private\": false\n}\n",
+			want:     "{\n  \"name\": \"winterfell\",\n  \"version\": \"1.1.0\",\n  \"license\": \"MIT\",\n\n  \"private\": false\n}\n",
+		},
+		{
+			name:     "AddedDependency",
+			ancestor: lock("1.0.0", `"lodash": {`, "4.17.20", `"react": {`, "17.0.1"),
+			target:   lock("1.1.0", `"axios": {`, "0.21.1", `"lodash": {`, "4.17.20", `"react": {`, "17.0.1"),
+			source:   lock("1.0.1", `"lodash": {`, "4.17.20", `"react": {`, "17.0.1", `"zod": {`, "3.0.0"),
+			want:     lock("1.1.0", `"axios": {`, "0.21.1", `"lodash": {`, "4.17.20", `"react": {`, "17.0.1", `"zod": {`, "3.0.0"),
+		},
+		{
+			name:     "AmbiguousVersions",
+			ancestor: "[deps]\nversion = 1\n",
+			target:   "[deps]\nversion = 2\n",
+			source:   "[deps]\nversion = 1\nversion = 3\n",
+			wantErr:  true,
+		},
+		{
+			name:     "OtherConflict",
+			ancestor: "name = winterfell\nversion = 1.0.0\n",
+			target:   "name = the wall\nversion = 1.1.0\n",
+			source:   "name = castle black\nversion = 1.0.1\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keepTargetVersion([]byte(tt.ancestor), []byte(tt.target), []byte(tt.source))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("keepTargetVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("keepTargetVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Renders a lock file with the given version followed by dependency names and versions.
+func lock(version string, dependencies ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "{\n  \"name\": \"winterfell\",\n  \"version\": \"%s\",\n  \"dependencies\": {\n", version)
+	for i := 0; i < len(dependencies); i += 2 {
+		fmt.Fprintf(&b, "    %s\n      \"version\": \"%s\"\n    }", dependencies[i], dependencies[i+1])
+		if i+2 < len(dependencies) {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strings"
+)
+
+type SignatureFormat string
+
+const (
+	SignatureOpenPGP SignatureFormat = "openpgp"
+	SignatureSSH     SignatureFormat = "ssh"
+)
+
+const (
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureVersion   = 1
+	sshSignatureNamespace = "git"
+	sshSignatureHash      = "sha512"
+)
+
+// A CommitSigner produces the armored detached signature of a commit content, as stored in the gpgsig header.
+type CommitSigner interface {
+	Sign(content []byte) (string, error)
+}
+
+// NewCommitSigner reads a private key from the given file according to the signature format. The passphrase is only
+// used when the key is encrypted.
+func NewCommitSigner(format SignatureFormat, keyFile string, passphrase string) (CommitSigner, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case SignatureOpenPGP:
+		return NewOpenPGPSigner(key, passphrase)
+	case SignatureSSH:
+		return NewSSHSigner(key, passphrase)
+	}
+
+	return nil, fmt.Errorf("unknown signature format %q", format)
+}
+
+type OpenPGPSigner struct {
+	Entity *openpgp.Entity
+}
+
+// NewOpenPGPSigner reads the first private key of an armored key ring.
+func NewOpenPGPSigner(armoredKey []byte, passphrase string) (*OpenPGPSigner, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+
+		// decrypt the primary key and the subkeys, any of them may be the signing key
+		if entity.PrivateKey.Encrypted {
+			err = entity.PrivateKey.Decrypt([]byte(passphrase))
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				err = subkey.PrivateKey.Decrypt([]byte(passphrase))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return &OpenPGPSigner{Entity: entity}, nil
+	}
+
+	return nil, errors.New("no private key found in key ring")
+}
+
+func (s *OpenPGPSigner) Sign(content []byte) (string, error) {
+	var b bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&b, s.Entity, bytes.NewReader(content), nil)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+type SSHSigner struct {
+	Signer ssh.Signer
+}
+
+// NewSSHSigner reads a PEM encoded private key.
+func NewSSHSigner(pemKey []byte, passphrase string) (*SSHSigner, error) {
+	var signer ssh.Signer
+	var err error
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemKey, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pemKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SSHSigner{Signer: signer}, nil
+}
+
+// Sign produces an armored SSH signature in the git namespace, see PROTOCOL.sshsig in the OpenSSH sources.
+func (s *SSHSigner) Sign(content []byte) (string, error) {
+	data := sshSignedData(sshSignatureNamespace, sshSignatureHash, content)
+
+	var signature *ssh.Signature
+	var err error
+	if as, ok := s.Signer.(ssh.AlgorithmSigner); ok && s.Signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-rsa signatures (SHA-1) are rejected by git
+		signature, err = as.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+	} else {
+		signature, err = s.Signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var blob bytes.Buffer
+	blob.WriteString(sshSignatureMagic)
+	binary.Write(&blob, binary.BigEndian, uint32(sshSignatureVersion))
+	writeSSHString(&blob, s.Signer.PublicKey().Marshal())
+	writeSSHString(&blob, []byte(sshSignatureNamespace))
+	writeSSHString(&blob, nil)
+	writeSSHString(&blob, []byte(sshSignatureHash))
+	writeSSHString(&blob, ssh.Marshal(signature))
+
+	return armorSSHSignature(blob.Bytes()), nil
+}
+
+// The data actually signed : the magic preamble, the namespace, a reserved field, the hash algorithm and the hash of
+// the message.
+func sshSignedData(namespace string, hash string, content []byte) []byte {
+	digest := sha512.Sum512(content)
+
+	var b bytes.Buffer
+	b.WriteString(sshSignatureMagic)
+	writeSSHString(&b, []byte(namespace))
+	writeSSHString(&b, nil)
+	writeSSHString(&b, []byte(hash))
+	writeSSHString(&b, digest[:])
+	return b.Bytes()
+}
+
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+func armorSSHSignature(blob []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		b.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString("-----END SSH SIGNATURE-----\n")
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+var signedContent = []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nAutomatic merge\n")
+
+func TestOpenPGPSigner_Sign(t *testing.T) {
+	entity, err := openpgp.NewEntity("Cascade Bot", "", "cascade@example.com", nil)
+	CheckFatal(err, t)
+
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	CheckFatal(err, t)
+	CheckFatal(entity.SerializePrivate(w, nil), t)
+	CheckFatal(w.Close(), t)
+
+	signer, err := NewOpenPGPSigner(key.Bytes(), "")
+	CheckFatal(err, t)
+
+	signature, err := signer.Sign(signedContent)
+	CheckFatal(err, t)
+
+	_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(signedContent), strings.NewReader(signature))
+	if err != nil {
+		t.Errorf("signature does not verify: %s", err)
+	}
+}
+
+func TestSSHSigner_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	CheckFatal(err, t)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	CheckFatal(err, t)
+
+	tests := []struct {
+		name      string
+		key       interface{}
+		algorithm string
+	}{
+		{"rsa", rsaKey, ssh.SigAlgoRSASHA2512},
+		{"ed25519", ed25519Key, ssh.KeyAlgoED25519},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ssh.NewSignerFromKey(tt.key)
+			CheckFatal(err, t)
+
+			armored, err := (&SSHSigner{Signer: s}).Sign(signedContent)
+			CheckFatal(err, t)
+
+			if !strings.HasPrefix(armored, "-----BEGIN SSH SIGNATURE-----\n") || !strings.HasSuffix(armored, "-----END SSH SIGNATURE-----\n") {
+				t.Fatalf("unexpected armor %q", armored)
+			}
+			lines := strings.Split(strings.TrimSpace(armored), "\n")
+			blob, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+			CheckFatal(err, t)
+
+			var sig struct {
+				Magic     [6]byte
+				Version   uint32
+				PublicKey []byte
+				Namespace string
+				Reserved  []byte
+				Hash      string
+				Signature []byte
+			}
+			CheckFatal(ssh.Unmarshal(blob, &sig), t)
+			if string(sig.Magic[:]) != "SSHSIG" || sig.Version != 1 || sig.Namespace != "git" || sig.Hash != "sha512" {
+				t.Fatalf("unexpected header %+v", sig)
+			}
+
+			pub, err := ssh.ParsePublicKey(sig.PublicKey)
+			CheckFatal(err, t)
+
+			signature := &ssh.Signature{}
+			CheckFatal(ssh.Unmarshal(sig.Signature, signature), t)
+			if signature.Format != tt.algorithm {
+				t.Errorf("Format = %s, want %s", signature.Format, tt.algorithm)
+			}
+
+			err = pub.Verify(sshSignedData("git", "sha512", signedContent), signature)
+			if err != nil {
+				t.Errorf("signature does not verify: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewSSHSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	CheckFatal(err, t)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	_, err = NewSSHSigner(pemKey, "")
+	CheckFatal(err, t)
+
+	_, err = NewSSHSigner([]byte("not a key"), "")
+	if err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
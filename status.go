@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ktrysmt/go-bitbucket"
+	"strings"
+)
+
+const (
+	CommitStatusInProgress = "INPROGRESS"
+	CommitStatusSuccessful = "SUCCESSFUL"
+	CommitStatusFailed     = "FAILED"
+)
+
+const DefaultBuildStatusKey = "cascade-merge"
+
+// BuildStatusConfig publishes a build status on the merge commits, and on the commit which cannot be merged.
+type BuildStatusConfig struct {
+	// Key identifies the status among the other builds of the commit, cascade-merge by default.
+	Key string `json:"key,omitempty"`
+	// Name is displayed in Bitbucket, Cascade merge by default.
+	Name string `json:"name,omitempty"`
+}
+
+// Status builds the options of a commit status. Bitbucket requires an URL, the URL of the repository is used when
+// none is given.
+func (bc *BuildStatusConfig) Status(r *Repository, state string, description string, url string) *bitbucket.CommitStatusOptions {
+	status := &bitbucket.CommitStatusOptions{
+		Key:         bc.Key,
+		Name:        bc.Name,
+		State:       state,
+		Description: description,
+		Url:         url,
+	}
+	if len(status.Key) == 0 {
+		status.Key = DefaultBuildStatusKey
+	}
+	if len(status.Name) == 0 {
+		status.Name = "Cascade merge"
+	}
+	if len(status.Url) == 0 {
+		status.Url = repositoryLink(r)
+	}
+	return status
+}
+
+// Statuses returns the final status of the merge commits of the cascade, and the failed status of the source commit
+// of the conflict, keyed by commit id.
+func (bc *BuildStatusConfig) Statuses(o *CascadeOutcome, conflictCommit string) map[string]*bitbucket.CommitStatusOptions {
+	statuses := make(map[string]*bitbucket.CommitStatusOptions)
+
+	var url string
+	if o.PullRequest != nil {
+		url = pullRequestLink(o.PullRequest)
+	}
+	for _, hop := range o.Hops {
+		if hop.Status == HopMerged {
+			statuses[hop.Commit] = bc.Status(o.Repository, CommitStatusSuccessful, fmt.Sprintf("Merged %s into %s", hop.Source, hop.Target), url)
+		}
+	}
+
+	if len(conflictCommit) > 0 && o.State.Conflict() != nil {
+		url := o.ConflictURL
+		if len(url) == 0 && o.PullRequest != nil {
+			url = pullRequestLink(o.PullRequest)
+		}
+		description := fmt.Sprintf("Cannot merge %s into %s: %s", o.State.Source, o.State.Target, strings.Join(o.State.Conflict().Paths, ", "))
+		statuses[conflictCommit] = bc.Status(o.Repository, CommitStatusFailed, description, url)
+	}
+
+	return statuses
+}
+
+func repositoryLink(r *Repository) string {
+	if r == nil {
+		return ""
+	}
+	if r.Links.Html != nil && len(r.Links.Html.Href) > 0 {
+		return r.Links.Html.Href
+	}
+	if len(r.FullName) > 0 {
+		return "https://bitbucket.org/" + r.FullName
+	}
+	return ""
+}
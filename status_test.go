@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildStatusConfig_Statuses(t *testing.T) {
+	outcome := &CascadeOutcome{
+		Repository: &Repository{FullName: "morphean-sa/winterfell"},
+		PullRequest: &PullRequest{
+			Id:    7,
+			Links: map[string]Link{"html": {Href: "https://bitbucket.org/morphean-sa/winterfell/pull-requests/7"}},
+		},
+		State:       &CascadeMergeState{Source: "release/3", Target: "develop", error: &ConflictError{Paths: []string{"foo"}}},
+		ConflictURL: "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8",
+		Hops: []*CascadeHop{
+			{Source: "release/1", Target: "release/2", Status: HopUpToDate},
+			{Source: "release/2", Target: "release/3", Status: HopMerged, Commit: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"},
+			{Source: "release/3", Target: "develop", Status: HopFailed},
+		},
+	}
+
+	statuses := (&BuildStatusConfig{Key: "cascade"}).Statuses(outcome, "b393f468241f6e2a5c1fd2c4c67a8e9f4b0d1a2c")
+	if len(statuses) != 2 {
+		t.Fatalf("len(statuses) = %d, want 2", len(statuses))
+	}
+
+	merged := statuses["420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"]
+	if merged.State != CommitStatusSuccessful || merged.Key != "cascade" || merged.Url != "https://bitbucket.org/morphean-sa/winterfell/pull-requests/7" {
+		t.Errorf("unexpected merge commit status %+v", merged)
+	}
+
+	failed := statuses["b393f468241f6e2a5c1fd2c4c67a8e9f4b0d1a2c"]
+	if failed.State != CommitStatusFailed || failed.Url != "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8" {
+		t.Errorf("unexpected conflict commit status %+v", failed)
+	}
+}
+
+func TestBuildStatusConfig_Status(t *testing.T) {
+	status := (&BuildStatusConfig{}).Status(&Repository{FullName: "morphean-sa/winterfell"}, CommitStatusInProgress, "Cascading develop", "")
+
+	if status.Key != DefaultBuildStatusKey || status.Name != "Cascade merge" {
+		t.Errorf("unexpected defaults %+v", status)
+	}
+	if status.Url != "https://bitbucket.org/morphean-sa/winterfell" {
+		t.Errorf("Url = %s, want the repository link", status.Url)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libgit2/git2go/v34"
+	"path"
+	"strings"
+)
+
+type Favor string
+
+const (
+	// FavorOurs keeps the lines of the branch receiving the merge.
+	FavorOurs Favor = "ours"
+	// FavorTheirs keeps the lines of the merged branch.
+	FavorTheirs Favor = "theirs"
+	// FavorUnion keeps the lines of both branches.
+	FavorUnion Favor = "union"
+)
+
+func (f Favor) fileFavor() (git.MergeFileFavor, error) {
+	switch f {
+	case FavorOurs:
+		return git.MergeFileFavorOurs, nil
+	case FavorTheirs:
+		return git.MergeFileFavorTheirs, nil
+	case FavorUnion:
+		return git.MergeFileFavorUnion, nil
+	}
+	return git.MergeFileFavorNormal, fmt.Errorf("unknown favor %q, expected ours, theirs or union", f)
+}
+
+// FavorRule resolves the conflicts of the files matching a glob. A glob without slash matches the file name in any
+// directory, like in .gitattributes.
+type FavorRule struct {
+	Path  string `json:"path"`
+	Favor Favor  `json:"favor"`
+}
+
+func (r *FavorRule) Match(p string) bool {
+	return matchPath(r.Path, p)
+}
+
+func matchPath(pattern string, p string) bool {
+	if !strings.Contains(pattern, "/") {
+		p = path.Base(p)
+	}
+	matched, _ := path.Match(pattern, p)
+	return matched
+}
+
+func validPath(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil && len(pattern) > 0
+}
+
+// MergeStrategy tunes how branches are merged.
+type MergeStrategy struct {
+	// RenameThreshold enables rename detection, files at least this similar (1-100) are considered renamed.
+	RenameThreshold uint `json:"rename_threshold,omitempty"`
+	// IgnoreWhitespace resolves the conflicts that only differ by whitespace.
+	IgnoreWhitespace bool `json:"ignore_whitespace,omitempty"`
+	// Favor is checked in order, the first rule matching a conflicting file applies.
+	Favor []FavorRule `json:"favor,omitempty"`
+}
+
+func (s *MergeStrategy) Validate() error {
+	if s.RenameThreshold > 100 {
+		return errors.New("rename_threshold must be between 1 and 100")
+	}
+	for _, rule := range s.Favor {
+		if !validPath(rule.Path) {
+			return fmt.Errorf("invalid path %q", rule.Path)
+		}
+		if _, err := rule.Favor.fileFavor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeOptions returns the options of the tree merge. Conflicts are kept in the index to be resolved file by file.
+func (s *MergeStrategy) MergeOptions() (*git.MergeOptions, error) {
+	opts, err := git.DefaultMergeOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.FileFavor = git.MergeFileFavorNormal
+	opts.TreeFlags = 0
+	if s != nil && s.RenameThreshold > 0 {
+		opts.TreeFlags |= git.MergeTreeFindRenames
+		opts.RenameThreshold = s.RenameThreshold
+	}
+	return &opts, nil
+}
+
+// FileOptions returns the options used to merge again a conflicting file, nil when the strategy cannot resolve it.
+func (s *MergeStrategy) FileOptions(p string) *git.MergeFileOptions {
+	if s == nil {
+		return nil
+	}
+
+	opts := &git.MergeFileOptions{Favor: git.MergeFileFavorNormal}
+	for _, rule := range s.Favor {
+		if rule.Match(p) {
+			opts.Favor, _ = rule.Favor.fileFavor()
+			break
+		}
+	}
+	if s.IgnoreWhitespace {
+		opts.Flags |= git.MergeFileIgnoreWhitespace
+	}
+
+	if opts.Favor == git.MergeFileFavorNormal && !s.IgnoreWhitespace {
+		return nil
+	}
+	return opts
+}
+
+// ResolveConflicts merges again the conflicting files according to the strategy and stages the clean results. The
+// paths still in conflict are returned.
+func (c *Client) ResolveConflicts(index *git.Index, strategy *MergeStrategy) ([]string, error) {
+	var conflicts []git.IndexConflict
+
+	iterator, err := index.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		conflict, err := iterator.Next()
+		if git.IsErrorCode(err, git.ErrorCodeIterOver) {
+			break
+		}
+		if err != nil {
+			iterator.Free()
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	iterator.Free()
+
+	var unresolved []string
+	for _, conflict := range conflicts {
+		// deleted on one side, nothing to merge
+		if conflict.Our == nil || conflict.Their == nil {
+			unresolved = append(unresolved, conflictPath(conflict))
+			continue
+		}
+
+		opts := strategy.FileOptions(conflict.Our.Path)
+		if opts == nil {
+			unresolved = append(unresolved, conflict.Our.Path)
+			continue
+		}
+
+		resolved, err := c.mergeFile(conflict, opts)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == nil {
+			unresolved = append(unresolved, conflict.Our.Path)
+			continue
+		}
+
+		err = index.RemoveConflict(conflict.Our.Path)
+		if err != nil {
+			return nil, err
+		}
+		err = index.Add(resolved)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return unresolved, nil
+}
+
+// Merges a conflicting file, the returned entry is nil when the result still has conflicts.
+func (c *Client) mergeFile(conflict git.IndexConflict, opts *git.MergeFileOptions) (*git.IndexEntry, error) {
+	ancestor := git.MergeFileInput{Path: conflict.Our.Path}
+	if conflict.Ancestor != nil {
+		input, err := c.mergeFileInput(conflict.Ancestor)
+		if err != nil {
+			return nil, err
+		}
+		ancestor = input
+	}
+	ours, err := c.mergeFileInput(conflict.Our)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := c.mergeFileInput(conflict.Their)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := git.MergeFile(ancestor, ours, theirs, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Free()
+
+	if !result.Automergeable {
+		return nil, nil
+	}
+
+	oid, err := c.Repository.CreateBlobFromBuffer(result.Contents)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := *conflict.Our
+	entry.Id = oid
+	entry.Size = uint32(len(result.Contents))
+	return &entry, nil
+}
+
+func (c *Client) mergeFileInput(entry *git.IndexEntry) (git.MergeFileInput, error) {
+	blob, err := c.Repository.LookupBlob(entry.Id)
+	if err != nil {
+		return git.MergeFileInput{}, err
+	}
+	defer blob.Free()
+
+	return git.MergeFileInput{
+		Path:     entry.Path,
+		Mode:     uint(entry.Mode),
+		Contents: blob.Contents(),
+	}, nil
+}
+
+func conflictPath(conflict git.IndexConflict) string {
+	for _, entry := range []*git.IndexEntry{conflict.Our, conflict.Their, conflict.Ancestor} {
+		if entry != nil {
+			return entry.Path
+		}
+	}
+	return ""
+}
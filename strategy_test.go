@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestFavorRule_Match(t *testing.T) {
+	tests := []struct {
+		rule FavorRule
+		path string
+		want bool
+	}{
+		{FavorRule{Path: "CHANGELOG.md"}, "CHANGELOG.md", true},
+		{FavorRule{Path: "CHANGELOG.md"}, "docs/CHANGELOG.md", true},
+		{FavorRule{Path: "*.lock"}, "web/yarn.lock", true},
+		{FavorRule{Path: "web/*.lock"}, "web/yarn.lock", true},
+		{FavorRule{Path: "web/*.lock"}, "api/web/yarn.lock", false},
+		{FavorRule{Path: "go.sum"}, "go.mod", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule.Path+" "+tt.path, func(t *testing.T) {
+			if got := tt.rule.Match(tt.path); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the body, keyed with the webhook secret.
+const WebhookSignatureHeader = "X-Cascade-Signature"
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// SecretEnv is the name of the environment variable holding the secret signing the deliveries.
+	SecretEnv string `json:"secret_env,omitempty"`
+}
+
+func (wc *WebhookConfig) Validate() error {
+	u, err := url.Parse(wc.URL)
+	if err != nil || len(u.Host) == 0 {
+		return fmt.Errorf("invalid webhook url %q", wc.URL)
+	}
+	return nil
+}
+
+// Notifier returns a webhook notifier, retries and dead letters are configured from the environment.
+func (wc *WebhookConfig) Notifier() *WebhookNotifier {
+	var secret string
+	if len(wc.SecretEnv) > 0 {
+		secret = os.Getenv(wc.SecretEnv)
+	}
+	return &WebhookNotifier{
+		URL:            wc.URL,
+		Secret:         secret,
+		Retries:        getEnvInt("WEBHOOK_RETRIES", 3),
+		RetryDelay:     getEnvDuration("WEBHOOK_RETRY_DELAY", time.Second),
+		DeadLetterFile: getEnv("WEBHOOK_DEAD_LETTER_FILE", ""),
+		Client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// WebhookPayload is the JSON document posted after each cascade.
+type WebhookPayload struct {
+	Event       string              `json:"event"`
+	Timestamp   time.Time           `json:"timestamp"`
+	Repository  WebhookRepository   `json:"repository"`
+	PullRequest *WebhookPullRequest `json:"pull_request,omitempty"`
+	Hops        []*CascadeHop       `json:"hops"`
+	// ConflictPullRequest links to the pull request created to solve the conflict.
+	ConflictPullRequest string `json:"conflict_pull_request,omitempty"`
+	Error               string `json:"error,omitempty"`
+	// ResumedFrom links a cascade resumed by the merge of a conflict pull request to the original one.
+	ResumedFrom *CascadeOrigin `json:"resumed_from,omitempty"`
+}
+
+type WebhookRepository struct {
+	Uuid     string `json:"uuid"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+type WebhookPullRequest struct {
+	Id          int    `json:"id"`
+	Title       string `json:"title"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	URL         string `json:"url,omitempty"`
+	Author      string `json:"author,omitempty"`
+}
+
+func NewWebhookPayload(o *CascadeOutcome) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:     "cascade:completed",
+		Timestamp: time.Now().UTC(),
+		Repository: WebhookRepository{
+			Uuid:     o.Repository.Uuid,
+			Name:     o.Repository.Name,
+			FullName: o.Repository.FullName,
+		},
+		Hops:                o.Hops,
+		ConflictPullRequest: o.ConflictURL,
+		ResumedFrom:         o.Origin,
+	}
+	if payload.Hops == nil {
+		payload.Hops = make([]*CascadeHop, 0)
+	}
+	if o.Failed() {
+		payload.Event = "cascade:failed"
+		payload.Error = errorText(o.State)
+	}
+	if p := o.PullRequest; p != nil {
+		payload.PullRequest = &WebhookPullRequest{
+			Id:          p.Id,
+			Title:       p.Title,
+			Source:      p.SourceBranchName(),
+			Destination: p.DestinationBranchName(),
+			URL:         pullRequestLink(p),
+		}
+		if p.Author != nil {
+			payload.PullRequest.Author = p.Author.DisplayName
+		}
+	}
+	return payload
+}
+
+// DeadLetter is a delivery which failed after every retry, appended to the dead letter file as a JSON line.
+type DeadLetter struct {
+	URL     string          `json:"url"`
+	Time    time.Time       `json:"time"`
+	Error   string          `json:"error"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// WebhookNotifier posts a WebhookPayload to an URL.
+type WebhookNotifier struct {
+	URL            string
+	Secret         string
+	Retries        int
+	RetryDelay     time.Duration
+	DeadLetterFile string
+	Client         *http.Client
+	// Stop interrupts the retries, the delivery goes to the dead letter file right away.
+	Stop <-chan struct{}
+}
+
+// Notify delivers the payload, retrying with an exponential delay until the last retry or until Stop is closed. A
+// failed delivery is written to the dead letter file, if any.
+func (n *WebhookNotifier) Notify(o *CascadeOutcome) error {
+	body, err := json.Marshal(NewWebhookPayload(o))
+	if err != nil {
+		return err
+	}
+
+	delay := n.RetryDelay
+retry:
+	for attempt := 0; ; attempt++ {
+		err = n.deliver(body)
+		if err == nil || attempt >= n.Retries {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-n.Stop:
+			err = fmt.Errorf("%s, retries interrupted", err)
+			break retry
+		}
+		delay *= 2
+	}
+
+	if err != nil && len(n.DeadLetterFile) > 0 {
+		if dlErr := n.deadLetter(body, err); dlErr != nil {
+			log.Printf("cannot write dead letter of %s: %s", n.URL, dlErr)
+		}
+	}
+	return err
+}
+
+func (n *WebhookNotifier) deliver(body []byte) error {
+	request, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(n.Secret) > 0 {
+		request.Header.Set(WebhookSignatureHeader, "sha256="+webhookSignature([]byte(n.Secret), body))
+	}
+
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) deadLetter(body []byte, cause error) error {
+	f, err := os.OpenFile(n.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(&DeadLetter{
+		URL:     n.URL,
+		Time:    time.Now().UTC(),
+		Error:   cause.Error(),
+		Payload: body,
+	})
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// The hex encoded HMAC-SHA256 of the body.
+func webhookSignature(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func webhookOutcome() *CascadeOutcome {
+	outcome := conflictOutcome()
+	outcome.Hops = []*CascadeHop{
+		{Source: "release/1", Target: "release/2", Status: HopMerged, Commit: "420b26f539230d0dcd2f5b95bc17d4c1db3ab70b"},
+		{Source: "release/2", Target: "release/3", Status: HopFailed, Error: outcome.State.Error()},
+	}
+	return outcome
+}
+
+// The delivery is signed with the secret and holds the hops. We expect the receiver to verify the signature.
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var payload WebhookPayload
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		signature = request.Header.Get(WebhookSignatureHeader)
+		body, _ = ioutil.ReadAll(request.Body)
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL, Secret: "winter is coming", Client: http.DefaultClient}
+	CheckFatal(n.Notify(webhookOutcome()), t)
+
+	if want := "sha256=" + webhookSignature([]byte("winter is coming"), body); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	CheckFatal(json.Unmarshal(body, &payload), t)
+	if payload.Event != "cascade:failed" || payload.Repository.FullName != "morphean-sa/winterfell" || payload.PullRequest.Id != 7 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if len(payload.Hops) != 2 || payload.Hops[1].Status != HopFailed {
+		t.Errorf("unexpected hops %+v", payload.Hops)
+	}
+	if payload.ConflictPullRequest != "https://bitbucket.org/morphean-sa/winterfell/pull-requests/8" {
+		t.Errorf("ConflictPullRequest = %q", payload.ConflictPullRequest)
+	}
+}
+
+// The receiver fails twice then accepts the delivery. We expect the delivery to succeed after two retries.
+func TestWebhookNotifier_Retry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		attempts++
+		if attempts < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL, Retries: 3, RetryDelay: time.Millisecond, Client: http.DefaultClient}
+	CheckFatal(n.Notify(webhookOutcome()), t)
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+// The receiver always fails. We expect an error and the delivery in the dead letter file.
+func TestWebhookNotifier_DeadLetter(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		attempts++
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dead-letter-")
+	CheckFatal(err, t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "webhooks.jsonl")
+
+	n := &WebhookNotifier{URL: server.URL, Retries: 2, RetryDelay: time.Millisecond, DeadLetterFile: file, Client: http.DefaultClient}
+	if err := n.Notify(webhookOutcome()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+
+	f, err := os.Open(file)
+	CheckFatal(err, t)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("dead letter file is empty")
+	}
+	var letter DeadLetter
+	CheckFatal(json.Unmarshal(scanner.Bytes(), &letter), t)
+
+	var payload WebhookPayload
+	CheckFatal(json.Unmarshal(letter.Payload, &payload), t)
+	if letter.URL != server.URL || len(letter.Error) == 0 || payload.PullRequest.Id != 7 {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+}
+
+// The shutdown is requested while the receiver fails. We expect the retries to stop and the delivery in the dead letter
+// file.
+func TestWebhookNotifier_Stop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "dead-letter-")
+	CheckFatal(err, t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "webhooks.jsonl")
+
+	stop := make(chan struct{})
+	close(stop)
+
+	n := &WebhookNotifier{URL: server.URL, Retries: 3, RetryDelay: time.Hour, DeadLetterFile: file, Client: http.DefaultClient, Stop: stop}
+	if err := n.Notify(webhookOutcome()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	stat, err := os.Stat(file)
+	CheckFatal(err, t)
+	if stat.Size() == 0 {
+		t.Error("dead letter file is empty")
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type Worker struct {
+	Events    <-chan PullRequestEvent
+	Health    *Health
+	Workspace *Workspace
+	Config    *Config
+	Process   func(e PullRequestEvent)
+	// Drifts holds the last reconciliation scan of each repository.
+	Drifts *DriftReport
+
+	heartbeat     time.Duration
+	maintenance   time.Duration
+	reconcile     time.Duration
+	reconcileMode string
+	maxResumes    int
+	stop          chan struct{}
+	done          chan struct{}
+	deliveries    sync.WaitGroup
+}
+
+func NewWorker(events <-chan PullRequestEvent, health *Health, workspace *Workspace, config *Config) *Worker {
+	if config == nil {
+		config = &Config{}
+	}
+	w := &Worker{
+		Events:        events,
+		Health:        health,
+		Workspace:     workspace,
+		Config:        config,
+		Drifts:        NewDriftReport(),
+		heartbeat:     getEnvDuration("HEARTBEAT_INTERVAL", 10*time.Second),
+		maintenance:   getEnvDuration("MAINTENANCE_INTERVAL", 24*time.Hour),
+		reconcile:     getEnvDuration("RECONCILE_INTERVAL", 0),
+		reconcileMode: getEnv("RECONCILE_MODE", ReconcileReport),
+		maxResumes:    getEnvInt("CASCADE_MAX_RESUMES", 5),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	w.Process = w.process
+	return w
+}
+
+// Run processes events one at the time until the channel is closed or Shutdown is called.
+func (w *Worker) Run() {
+	defer close(w.done)
+
+	// webhooks are delivered in the background, their retries are interrupted by the shutdown
+	defer w.deliveries.Wait()
+
+	// beat regularly while idle so readiness can tell a hung worker from a quiet one
+	ticker := time.NewTicker(w.heartbeat)
+	defer ticker.Stop()
+
+	// working copies are maintained by the worker itself so that it never runs during a cascade
+	var maintenance <-chan time.Time
+	if w.maintenance > 0 && w.Workspace != nil {
+		t := time.NewTicker(w.maintenance)
+		defer t.Stop()
+		maintenance = t.C
+	}
+
+	// scans share the working copies with the cascades, they run between two events
+	var reconcile <-chan time.Time
+	if w.reconcile > 0 && w.Workspace != nil {
+		t := time.NewTicker(w.reconcile)
+		defer t.Stop()
+		reconcile = t.C
+	}
+
+	for {
+		// do not pick another event once a shutdown is requested
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case e, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			w.Process(e)
+			w.Health.Beat()
+		case <-ticker.C:
+			w.Health.Beat()
+		case <-maintenance:
+			err := w.Workspace.Maintain()
+			if err != nil {
+				log.Printf("workspace maintenance failed: %s", err)
+			}
+			w.Health.Beat()
+		case <-reconcile:
+			w.Reconcile()
+			w.Health.Beat()
+		}
+	}
+}
+
+// Shutdown asks the worker to stop after the event currently processed and waits for it until the context is done.
+// It returns the events left in the queue, which will not be processed by this worker.
+func (w *Worker) Shutdown(ctx context.Context) ([]PullRequestEvent, error) {
+	close(w.stop)
+
+	var err error
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	remaining := make([]PullRequestEvent, 0)
+	for {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return remaining, err
+			}
+			remaining = append(remaining, e)
+		default:
+			return remaining, err
+		}
+	}
+}
+
+func (w *Worker) process(e PullRequestEvent) {
+	_, err := w.Cascade(e)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// Cascade merges the destination branch of the pull request of the event into the following branches, then publishes
+// and notifies the outcome. A nil outcome is returned when the pull request is not cascaded.
+func (w *Worker) Cascade(e PullRequestEvent) (*CascadeOutcome, error) {
+
+	// retrieve auth from environment
+	username := getEnv("BITBUCKET_USERNAME", "")
+	password := getEnv("BITBUCKET_PASSWORD", "")
+
+	// get the clone url which is not provided in the webhook
+	api := NewBitbucket(username, password, e.Repository.Owner.UUID, e.Repository.Name)
+	url, err := api.GetCloneURL("https")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read clone url of %s (owner=%s): %s", e.Repository.Name, e.Repository.Owner.UUID, err)
+	}
+
+	// the payload cannot be trusted, the access lists are checked against the repository the API works on
+	repository, err := api.GetRepository()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read repository %s (owner=%s): %s", e.Repository.Name, e.Repository.Owner.UUID, err)
+	}
+	if ok, reason := w.Config.Access.Allowed(repository); !ok {
+		return nil, fmt.Errorf("refused cascade of %s: %s", repository.FullName, reason)
+	}
+	// a cascade started by hand only knows the full name of the repository
+	e.Repository.Uuid = repository.Uuid
+	e.Repository.FullName = repository.FullName
+
+	// query repository branching model to know which branches are candidate for cascading
+	opts, err := api.GetCascadeOptions(e.Repository.Owner.UUID, e.Repository.Name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot detect cascade options for %s, check branching model", e.Repository.Name)
+	}
+	opts.FullFetch = getEnvBool("FULL_FETCH", false)
+	opts.PullRequest = e.PullRequest
+
+	// markers of the pull request
+	if e.PullRequest.SkipCascade() {
+		log.Printf("pull request #%d of %s is marked no-cascade, skipping", e.PullRequest.Id, e.Repository.Name)
+		return nil, nil
+	}
+	opts.Until = e.PullRequest.CascadeUntil()
+
+	// the merge of a conflict pull request resumes the cascade it stopped, a bounded number of times
+	origin := w.resumedOrigin(api, e.PullRequest)
+	if origin != nil {
+		if origin.Resumes > w.maxResumes {
+			log.Printf("pull request #%d of %s resumed the cascade %d times, stopping", e.PullRequest.Id, e.Repository.Name, origin.Resumes)
+			return nil, nil
+		}
+		log.Printf("pull request #%d of %s resumes the cascade of #%d from %s", e.PullRequest.Id, e.Repository.Name, origin.PullRequest, e.PullRequest.DestinationBranchName())
+	}
+
+	// settings of this repository, validated when the configuration was loaded
+	rc := w.Config.Repository(e.Repository)
+	opts.Rules = rc.Cascade
+
+	// check destination branch is candidate for auto merge before cloning
+	destination := e.PullRequest.DestinationBranchName()
+	if ok, reason := opts.Eligible(destination); !ok {
+		log.Printf("pull request #%d of %s is not cascaded: %s", e.PullRequest.Id, e.Repository.Name, reason)
+		return nil, nil
+	}
+
+	message, err := NewMergeMessageTemplate(rc.MergeMessage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge message template for %s: %s", e.Repository.Name, err)
+	}
+
+	var signer CommitSigner
+	if rc.Signing != nil {
+		signer, err = rc.Signing.Signer()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load signing key for %s: %s", e.Repository.Name, err)
+		}
+	}
+
+	// a full fetch needs a full clone
+	var refspecs []string
+	if !opts.FullFetch {
+		refspecs = opts.Refspecs(DefaultRemoteName)
+	}
+
+	// make room for the working copy before cloning
+	evicted, err := w.Workspace.Evict(e.Repository.Uuid)
+	for _, path := range evicted {
+		log.Printf("evicted working copy %s", path)
+	}
+	if err != nil {
+		log.Printf("cannot evict working copies: %s", err)
+	}
+
+	c, err := NewClient(&ClientOptions{
+		Path:     w.Workspace.Path(e.Repository.Uuid),
+		URL:      url,
+		Bare:     true,
+		Refspecs: refspecs,
+		Credentials: &Credentials{
+			Username: username,
+			Password: password,
+		},
+		MergeMessage:    message,
+		Author:          rc.CommitterAuthor(),
+		AuthorMode:      rc.Author,
+		Signer:          signer,
+		Strategy:        rc.Merge,
+		ResolutionRules: rc.Resolve,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize git repository: %s", err)
+	}
+	defer c.Close()
+	defer w.Workspace.Touch(e.Repository.Uuid)
+
+	// cascade merge the pull request
+	outcome := &CascadeOutcome{Repository: e.Repository, PullRequest: e.PullRequest, Origin: origin}
+	opts.OnHop = func(hop *CascadeHop) {
+		outcome.Hops = append(outcome.Hops, hop)
+
+		// the pushed merge commit is in progress until the end of the cascade
+		if rc.BuildStatus != nil && hop.Status == HopMerged {
+			status := rc.BuildStatus.Status(e.Repository, CommitStatusInProgress, fmt.Sprintf("Cascading %s", hop.Target), pullRequestLink(e.PullRequest))
+			if err := api.CreateCommitStatus(hop.Commit, status); err != nil {
+				log.Printf("cannot publish the status of %s on %s: %s", hop.Commit, e.Repository.Name, err)
+			}
+		}
+	}
+	outcome.State = c.CascadeMerge(destination, opts)
+	if outcome.State.Conflict() != nil {
+
+		// create a new pull request when cascade stops on a conflict
+		outcome.ConflictURL, err = w.openConflictPullRequest(api, c, rc.ConflictPullRequest, e.PullRequest, outcome.State, opts.Until, origin.Next(e.PullRequest))
+		if err != nil {
+			log.Printf("could not create a pull request %s to %s on %s", outcome.State.Source, outcome.State.Target, e.Repository.Name)
+		}
+	} else if outcome.State != nil {
+		log.Printf("cascade of %s failed on %s: %s", destination, e.Repository.Name, errorText(outcome.State))
+	}
+
+	if rc.BuildStatus != nil {
+		w.publishStatuses(api, c, rc.BuildStatus, outcome)
+	}
+
+	if rc.Comment {
+		w.comment(api, outcome)
+	}
+
+	if rc.Email != nil && outcome.State.Conflict() != nil && !e.PullRequest.Manual() {
+		outcome.AuthorEmail = w.authorEmail(api, c, rc.Email, e.PullRequest)
+	}
+
+	w.notify(rc, outcome)
+	return outcome, nil
+}
+
+// Returns the origin of a merged conflict pull request opened by the service, nil for any other pull request.
+func (w *Worker) resumedOrigin(api *Bitbucket, pr *PullRequest) *CascadeOrigin {
+	origin := pr.CascadeOrigin()
+	if origin == nil || pr.Author == nil {
+		return nil
+	}
+
+	// anybody can copy the trailer, only trust the pull requests of the service
+	bot, err := api.CurrentUserUUID()
+	if err != nil {
+		log.Printf("cannot tell whether pull request #%d resumes a cascade: %s", pr.Id, err)
+		return nil
+	}
+	if pr.Author.UUID != bot {
+		return nil
+	}
+	return origin
+}
+
+// Comment the outcome on the triggering pull request and, when the cascade was resumed, on the original one.
+func (w *Worker) comment(api *Bitbucket, outcome *CascadeOutcome) {
+	var ids []int
+	if !outcome.PullRequest.Manual() {
+		ids = append(ids, outcome.PullRequest.Id)
+	}
+	if outcome.Origin != nil && outcome.Origin.PullRequest > 0 {
+		ids = append(ids, outcome.Origin.PullRequest)
+	}
+
+	content := CascadeComment(outcome)
+	for _, id := range ids {
+		err := api.AddPullRequestComment(id, content)
+		if err != nil {
+			log.Printf("cannot comment pull request #%d of %s: %s", id, outcome.Repository.Name, err)
+		}
+	}
+}
+
+// Open the pull request solving the merge which stopped the cascade, reviewed by the author and the approvers of the
+// triggering pull request. Its description links to the origin of the cascade and keeps its until marker, so that
+// merging it resumes the cascade.
+func (w *Worker) openConflictPullRequest(api *Bitbucket, c *Client, cc *ConflictPullRequestConfig, pr *PullRequest, state *CascadeMergeState, until string, origin *CascadeOrigin) (string, error) {
+	reviewers := pr.Reviewers()
+	if cc != nil && cc.DefaultReviewers {
+		defaults, err := api.GetDefaultReviewers()
+		if err != nil {
+			log.Printf("cannot read default reviewers: %s", err)
+		}
+		reviewers = appendUnique(reviewers, defaults...)
+	}
+
+	// the author of the pull request cannot review it
+	if len(reviewers) > 0 {
+		if bot, err := api.CurrentUserUUID(); err == nil {
+			reviewers = removeString(reviewers, bot)
+		}
+	}
+
+	// merging the intermediate branch leaves the source branch untouched, it is closed once merged
+	source := state.Source
+	intermediate := cc != nil && cc.IntermediateBranch
+	if intermediate {
+		source = IntermediateBranchName(state.Source, state.Target)
+		err := c.CreateIntermediateBranch(state.Source, source)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	description := "There was a merge conflict automatically merging this branch\n\n"
+	if len(until) > 0 {
+		description += fmt.Sprintf("[cascade-until %s]\n", until)
+	}
+	description += origin.Trailer()
+
+	return api.CreatePullRequest(
+		"Automatic merge failure",
+		description,
+		source,
+		state.Target,
+		reviewers,
+		intermediate)
+}
+
+// Publish the final build statuses of the merge commits and of the commit which cannot be merged.
+func (w *Worker) publishStatuses(api *Bitbucket, c *Client, bc *BuildStatusConfig, outcome *CascadeOutcome) {
+	var conflictCommit string
+	if outcome.State.Conflict() != nil {
+		commit, err := c.LookupRemoteCommit(outcome.State.Source)
+		if err != nil {
+			log.Printf("cannot read the head of %s: %s", outcome.State.Source, err)
+		} else {
+			conflictCommit = commit.Id().String()
+			commit.Free()
+		}
+	}
+
+	for revision, status := range bc.Statuses(outcome, conflictCommit) {
+		if err := api.CreateCommitStatus(revision, status); err != nil {
+			log.Printf("cannot publish the status of %s on %s: %s", revision, outcome.Repository.Name, err)
+		}
+	}
+}
+
+// Resolve the email address of the author of the pull request. The Bitbucket API does not disclose email addresses,
+// the user is looked up to match the configured addresses by account id, UUID or nickname, then the author of the
+// head commit of the pull request is used.
+func (w *Worker) authorEmail(api *Bitbucket, c *Client, ec *EmailConfig, pr *PullRequest) string {
+	if pr.Author != nil {
+		keys := []string{pr.Author.AccountId, pr.Author.UUID}
+		if len(pr.Author.UUID) > 0 {
+			user, err := api.GetUser(pr.Author.UUID)
+			if err != nil {
+				log.Printf("cannot read user %s: %s", pr.Author.UUID, err)
+			} else {
+				keys = append(keys, user.AccountId, user.Uuid, user.Nickname)
+			}
+		}
+		if address := ec.Address(keys...); len(address) > 0 {
+			return address
+		}
+	}
+	return c.PullRequestAuthorEmail(pr)
+}
+
+// Tell the configured notifiers and webhooks about the outcome of a cascade, failed notifications are logged.
+func (w *Worker) notify(rc *RepositoryConfig, outcome *CascadeOutcome) {
+	for i := range rc.Notify {
+		n, err := rc.Notify[i].Notifier()
+		if err == nil {
+			err = n.Notify(outcome)
+		}
+		if err != nil {
+			log.Printf("cannot notify %s of the cascade on %s: %s", rc.Notify[i].Type, outcome.Repository.Name, err)
+		}
+	}
+	if rc.Email != nil {
+		n, err := rc.Email.Notifier()
+		if err == nil {
+			err = n.Notify(outcome)
+		}
+		if err != nil {
+			log.Printf("cannot email the conflict of the cascade on %s: %s", outcome.Repository.Name, err)
+		}
+	}
+
+	// retries must not hold the next cascades
+	for i := range rc.Webhooks {
+		n := rc.Webhooks[i].Notifier()
+		n.Stop = w.stop
+		w.deliveries.Add(1)
+		go func() {
+			defer w.deliveries.Done()
+			err := n.Notify(outcome)
+			if err != nil {
+				log.Printf("cannot deliver webhook %s of the cascade on %s: %s", n.URL, outcome.Repository.Name, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The worker is processing an event when the shutdown is requested. We expect the event to complete and the queued
+// events to be returned.
+func TestWorker_Shutdown(t *testing.T) {
+	events := make(chan PullRequestEvent, 3)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	processed := make([]int, 0)
+
+	w := NewWorker(events, NewHealth(), nil, nil)
+	w.Process = func(e PullRequestEvent) {
+		close(started)
+		<-release
+		processed = append(processed, e.PullRequest.Id)
+	}
+
+	events <- PullRequestEvent{PullRequest: &PullRequest{Id: 1}}
+	go w.Run()
+	<-started
+
+	events <- PullRequestEvent{PullRequest: &PullRequest{Id: 2}}
+	events <- PullRequestEvent{PullRequest: &PullRequest{Id: 3}}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	remaining, err := w.Shutdown(ctx)
+	CheckFatal(err, t)
+
+	if len(processed) != 1 || processed[0] != 1 {
+		t.Errorf("processed = %v, want [1]", processed)
+	}
+	if len(remaining) != 2 || remaining[0].PullRequest.Id != 2 || remaining[1].PullRequest.Id != 3 {
+		t.Errorf("remaining = %v, want events 2 and 3", remaining)
+	}
+}
+
+// The current event does not complete before the deadline. We expect an error.
+func TestWorker_ShutdownDeadline(t *testing.T) {
+	events := make(chan PullRequestEvent, 1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	w := NewWorker(events, NewHealth(), nil, nil)
+	w.Process = func(e PullRequestEvent) {
+		close(started)
+		<-release
+	}
+
+	events <- PullRequestEvent{PullRequest: &PullRequest{Id: 1}}
+	go w.Run()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := w.Shutdown(ctx)
+	if err == nil {
+		t.Error("shutdown must fail when the deadline is exceeded")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libgit2/git2go/v34"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Workspace is the directory holding a working copy per repository. It keeps the clones within a count and size
+// budget by evicting the least recently used ones.
+type Workspace struct {
+	Root     string
+	MaxCount int
+	MaxSize  int64
+}
+
+type WorkingCopy struct {
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+func NewWorkspace(root string, maxCount int, maxSize int64) (*Workspace, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create workspace %s : %s", root, err)
+	}
+	return &Workspace{
+		Root:     root,
+		MaxCount: maxCount,
+		MaxSize:  maxSize,
+	}, nil
+}
+
+// Path returns the location of the working copy of the given repository.
+func (w *Workspace) Path(uuid string) string {
+	return filepath.Join(w.Root, uuid)
+}
+
+// Touch marks the working copy of the given repository as recently used.
+func (w *Workspace) Touch(uuid string) error {
+	now := time.Now()
+	return os.Chtimes(w.Path(uuid), now, now)
+}
+
+// List the working copies of the workspace from the least to the most recently used.
+func (w *Workspace) List() ([]*WorkingCopy, error) {
+	entries, err := ioutil.ReadDir(w.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make([]*WorkingCopy, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(w.Root, entry.Name())
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, &WorkingCopy{
+			Path:     path,
+			Size:     size,
+			LastUsed: entry.ModTime(),
+		})
+	}
+
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].LastUsed.Before(copies[j].LastUsed)
+	})
+
+	return copies, nil
+}
+
+// Evict removes the least recently used working copies until the workspace fits in its budget. The working copy of
+// the repository given as keep is never evicted, a slot is reserved for it when it is not cloned yet. It returns the
+// removed paths.
+func (w *Workspace) Evict(keep string) ([]string, error) {
+	removed := make([]string, 0)
+	if w.MaxCount <= 0 && w.MaxSize <= 0 {
+		return removed, nil
+	}
+
+	copies, err := w.List()
+	if err != nil {
+		return removed, err
+	}
+
+	var total int64
+	for _, c := range copies {
+		total += c.Size
+	}
+
+	count := len(copies)
+	// the working copy to keep is about to be cloned, make room for it
+	if len(keep) > 0 {
+		if _, err := os.Stat(w.Path(keep)); os.IsNotExist(err) {
+			count++
+		}
+	}
+	for _, c := range copies {
+		overCount := w.MaxCount > 0 && count > w.MaxCount
+		overSize := w.MaxSize > 0 && total > w.MaxSize
+		if !overCount && !overSize {
+			break
+		}
+		if len(keep) > 0 && c.Path == w.Path(keep) {
+			continue
+		}
+
+		err = os.RemoveAll(c.Path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, c.Path)
+		count--
+		total -= c.Size
+	}
+
+	return removed, nil
+}
+
+// Maintain repacks every working copy of the workspace, a working copy that cannot be repacked is removed and will
+// be cloned again on next use.
+func (w *Workspace) Maintain() error {
+	copies, err := w.List()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range copies {
+		r, err := git.OpenRepository(c.Path)
+		if err != nil {
+			log.Printf("removing %s which is not a repository: %s", c.Path, err)
+			os.RemoveAll(c.Path)
+			continue
+		}
+
+		client := &Client{Repository: r}
+		err = client.Repack()
+		client.Close()
+
+		if err != nil {
+			log.Printf("removing %s which cannot be repacked: %s", c.Path, err)
+			os.RemoveAll(c.Path)
+			continue
+		}
+
+		// maintenance does not count as a use
+		os.Chtimes(c.Path, c.LastUsed, c.LastUsed)
+
+		size, _ := dirSize(c.Path)
+		log.Printf("repacked %s (%d -> %d bytes)", c.Path, c.Size, size)
+	}
+
+	return nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
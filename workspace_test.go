@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/libgit2/git2go/v34"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWorkspace_Evict(t *testing.T) {
+	type fields struct {
+		MaxCount int
+		MaxSize  int64
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		keep   string
+		want   []string
+	}{
+		{name: "Unlimited", fields: fields{}, want: []string{}},
+		{name: "Count", fields: fields{MaxCount: 2}, want: []string{"a"}},
+		{name: "Size", fields: fields{MaxSize: 150}, want: []string{"a", "b"}},
+		{name: "Keep", fields: fields{MaxCount: 2}, keep: "a", want: []string{"b"}},
+		{name: "KeepNotCloned", fields: fields{MaxCount: 2}, keep: "d", want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir(os.TempDir(), "cascade-workspace-")
+			CheckFatal(err, t)
+			defer os.RemoveAll(root)
+
+			w, err := NewWorkspace(root, tt.fields.MaxCount, tt.fields.MaxSize)
+			CheckFatal(err, t)
+
+			// a is the least recently used, c the most recently used
+			for i, name := range []string{"a", "b", "c"} {
+				CheckFatal(os.Mkdir(w.Path(name), 0755), t)
+				CheckFatal(ioutil.WriteFile(filepath.Join(w.Path(name), "data"), make([]byte, 100), 0644), t)
+				when := time.Now().Add(time.Duration(i-3) * time.Hour)
+				CheckFatal(os.Chtimes(w.Path(name), when, when), t)
+			}
+
+			removed, err := w.Evict(tt.keep)
+			CheckFatal(err, t)
+
+			got := make([]string, 0)
+			for _, path := range removed {
+				got = append(got, filepath.Base(path))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkspace_Maintain(t *testing.T) {
+	var path = filepath.Join(os.TempDir(), "maintain-"+time.Nanosecond.String()+".git")
+	os.RemoveAll(path)
+
+	bare, err := git.InitRepository(path, true)
+	CheckFatal(err, t)
+	defer os.RemoveAll(path)
+	defer bare.Free()
+
+	err = WorkOnBareRepository(bare,
+		&InitializeWithReadmeTask{t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "release/1", Filename: "foo", t: t},
+		&CreateDummyFileOnBranchTask{BranchName: "develop", Filename: "bar", t: t},
+	)
+	CheckFatal(err, t)
+
+	root, err := ioutil.TempDir(os.TempDir(), "cascade-workspace-")
+	CheckFatal(err, t)
+	defer os.RemoveAll(root)
+
+	w, err := NewWorkspace(root, 0, 0)
+	CheckFatal(err, t)
+
+	client, err := NewClient(&ClientOptions{
+		Path:   w.Path("winterfell"),
+		URL:    bare.Path(),
+		Author: &Author{Name: "Jon Snow", Email: "jon.snow@winterfell.net"},
+	})
+	CheckFatal(err, t)
+	err = client.Checkout("release/1")
+	CheckFatal(err, t)
+	client.CommitDummyFile("baz", t)
+	client.Close()
+
+	err = w.Maintain()
+	CheckFatal(err, t)
+
+	// every loose object is packed
+	objects := filepath.Join(w.Path("winterfell"), ".git", "objects")
+	entries, err := ioutil.ReadDir(objects)
+	CheckFatal(err, t)
+	for _, e := range entries {
+		if e.IsDir() && len(e.Name()) == 2 {
+			t.Errorf("loose objects directory %s must be removed", e.Name())
+		}
+	}
+
+	// branches are still readable
+	client, err = NewClient(&ClientOptions{Path: w.Path("winterfell"), URL: bare.Path()})
+	CheckFatal(err, t)
+	defer client.Close()
+
+	for _, branch := range []string{"release/1", "develop"} {
+		err = client.Checkout(branch)
+		CheckFatal(err, t)
+	}
+	err = client.Checkout("release/1")
+	CheckFatal(err, t)
+	_, err = client.ReadFile("baz")
+	CheckFatal(err, t)
+}